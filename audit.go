@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defAudit       = 100    // Responses kept in the audit log
+	defAuditValues = 100000 // Values kept across every response in the audit log
+)
+
+// AuditEntry records the values served in one response
+type AuditEntry struct {
+	Time     time.Time         `json:"time"`     // When the response was served
+	Tick     uint64            `json:"tick"`     // Snapshot the values came from
+	Client   string            `json:"client"`   // Remote address of the request
+	Endpoint string            `json:"endpoint"` // Request path
+	Values   map[string]string `json:"values"`   // Values served by series ID
+}
+
+// AuditLog keeps the most recent responses, bounded by both
+// the number of responses and the values they hold,
+// so scrapes of many series cannot grow it past MaxValues
+type AuditLog struct {
+	MU        sync.Mutex
+	Entries   []AuditEntry // Oldest first
+	Size      int          // How many entries are kept
+	MaxValues int          // How many values are kept across all entries
+	Values    int          // Values held by Entries
+}
+
+// NewAuditLog returns a log of the given sizes, or nil when either is zero
+func NewAuditLog(size, maxValues int) *AuditLog {
+	if size <= 0 || maxValues <= 0 {
+		return nil
+	}
+	return &AuditLog{Size: size, MaxValues: maxValues}
+}
+
+// Record adds a response to the log, dropping the oldest entries to make room.
+// The newest entry is always kept, even when it alone holds more than MaxValues.
+// Only the value of each sample is kept, not its labels, buffer or history.
+// A nil log records nothing.
+func (al *AuditLog) Record(r *http.Request, tick uint64, samples []Sample) {
+	if al == nil {
+		return
+	}
+
+	values := make(map[string]string, len(samples))
+	for _, s := range samples {
+		values[s.ID] = s.Value
+	}
+	entry := AuditEntry{
+		Time:     time.Now(),
+		Tick:     tick,
+		Client:   r.RemoteAddr,
+		Endpoint: r.URL.Path,
+		Values:   values,
+	}
+
+	al.MU.Lock()
+	defer al.MU.Unlock()
+
+	al.Entries = append(al.Entries, entry)
+	al.Values += len(values)
+
+	drop := 0
+	for len(al.Entries)-drop > al.Size || (al.Values > al.MaxValues && drop < len(al.Entries)-1) {
+		al.Values -= len(al.Entries[drop].Values)
+		drop++
+	}
+	if drop > 0 {
+		// Copy down, so dropped entries are not held by the backing array
+		n := copy(al.Entries, al.Entries[drop:])
+		clear(al.Entries[n:])
+		al.Entries = al.Entries[:n]
+	}
+}
+
+// AuditFilter selects entries from the log, zero values match everything
+type AuditFilter struct {
+	Client    string // Remote address, with or without the port
+	Endpoint  string // Request path
+	Series    string // Only entries that served this series, and only its value
+	SinceTick uint64 // Entries at or after this tick
+	Limit     int    // Most recent entries to return
+}
+
+// Query returns matching entries, oldest first
+func (al *AuditLog) Query(f AuditFilter) []AuditEntry {
+	if al == nil {
+		return []AuditEntry{}
+	}
+
+	al.MU.Lock()
+	ordered := slices.Clone(al.Entries)
+	al.MU.Unlock()
+
+	matched := make([]AuditEntry, 0)
+	for _, e := range ordered {
+		if f.Client != "" && e.Client != f.Client && clientHost(e.Client) != f.Client {
+			continue
+		}
+		if f.Endpoint != "" && e.Endpoint != f.Endpoint {
+			continue
+		}
+		if e.Tick < f.SinceTick {
+			continue
+		}
+		if f.Series != "" {
+			value, ok := e.Values[f.Series]
+			if !ok {
+				continue
+			}
+			e.Values = map[string]string{f.Series: value}
+		}
+		matched = append(matched, e)
+	}
+
+	if f.Limit > 0 && f.Limit < len(matched) {
+		matched = matched[len(matched)-f.Limit:]
+	}
+	return matched
+}
+
+func clientHost(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// AuditHandler returns served values as JSON, filtered by query parameters:
+// client, endpoint, series, since_tick and limit
+func (eph *EPHandle) AuditHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := AuditFilter{
+		Client:   q.Get("client"),
+		Endpoint: q.Get("endpoint"),
+		Series:   q.Get("series"),
+	}
+
+	if st := q.Get("since_tick"); st != "" {
+		tick, err := strconv.ParseUint(st, 10, 64)
+		if err != nil {
+			slog.Error("Invalid audit tick: " + st)
+			http.Error(w, "Invalid audit tick: "+st, http.StatusBadRequest)
+			return
+		}
+		filter.SinceTick = tick
+	}
+	if l := q.Get("limit"); l != "" {
+		limit, err := strconv.Atoi(l)
+		if err != nil || limit < 0 {
+			slog.Error("Invalid audit limit: " + l)
+			http.Error(w, "Invalid audit limit: "+l, http.StatusBadRequest)
+			return
+		}
+		filter.Limit = limit
+	}
+
+	entries := eph.Audit.Query(filter)
+
+	slog.Info("Audit match",
+		slog.String("method", r.Method),
+		slog.String("request", r.RequestURI),
+		slog.String("remote_addr", r.RemoteAddr),
+		slog.Int("entries", len(entries)))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
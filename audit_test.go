@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuditLog_Query(t *testing.T) {
+	al := NewAuditLog(3, 100)
+
+	requests := []struct {
+		client string
+		path   string
+		tick   uint64
+		value  string
+	}{
+		{client: "10.0.0.1:5000", path: "/metrics", tick: 1, value: "1"},
+		{client: "10.0.0.2:5000", path: "/metrics", tick: 2, value: "2"},
+		{client: "10.0.0.1:5001", path: "/series/int/up", tick: 3, value: "3"},
+		{client: "10.0.0.1:5002", path: "/metrics", tick: 4, value: "4"},
+	}
+	for _, req := range requests {
+		r := httptest.NewRequest("GET", req.path, nil)
+		r.RemoteAddr = req.client
+		al.Record(r, req.tick, []Sample{
+			{ID: "Metric_int_up", Value: req.value},
+			{ID: "Metric_int_down", Value: req.value},
+		})
+	}
+
+	tests := []struct {
+		name   string
+		filter AuditFilter
+		ticks  []uint64
+	}{
+		{name: "Oldest entry is dropped", filter: AuditFilter{}, ticks: []uint64{2, 3, 4}},
+		{name: "Client host", filter: AuditFilter{Client: "10.0.0.1"}, ticks: []uint64{3, 4}},
+		{name: "Client address", filter: AuditFilter{Client: "10.0.0.1:5002"}, ticks: []uint64{4}},
+		{name: "Endpoint", filter: AuditFilter{Endpoint: "/metrics"}, ticks: []uint64{2, 4}},
+		{name: "Since tick", filter: AuditFilter{SinceTick: 3}, ticks: []uint64{3, 4}},
+		{name: "Limit", filter: AuditFilter{Limit: 1}, ticks: []uint64{4}},
+		{name: "Unknown series", filter: AuditFilter{Series: "Metric_exp_up"}, ticks: []uint64{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := al.Query(tt.filter)
+			assertInt(t, len(got), len(tt.ticks))
+			for i, e := range got {
+				assertInt64(t, int64(e.Tick), int64(tt.ticks[i]))
+			}
+		})
+	}
+
+	t.Run("Series only returns its own value", func(t *testing.T) {
+		got := al.Query(AuditFilter{Series: "Metric_int_up"})
+		assertInt(t, len(got), 3)
+		for _, e := range got {
+			assertInt(t, len(e.Values), 1)
+		}
+	})
+
+	t.Run("Values are capped across entries", func(t *testing.T) {
+		capped := NewAuditLog(10, 5)
+		record := func(tick uint64, n int) {
+			samples := make([]Sample, n)
+			for i := range samples {
+				samples[i] = Sample{ID: fmt.Sprintf("series_%d", i), Value: "1"}
+			}
+			capped.Record(httptest.NewRequest("GET", "/metrics", nil), tick, samples)
+		}
+
+		for tick := uint64(1); tick <= 3; tick++ {
+			record(tick, 2)
+		}
+		got := capped.Query(AuditFilter{})
+		assertInt(t, len(got), 2)
+		assertInt64(t, int64(got[0].Tick), 2)
+		assertInt(t, capped.Values, 4)
+
+		// A response over the cap is still kept, alone
+		record(4, 7)
+		got = capped.Query(AuditFilter{})
+		assertInt(t, len(got), 1)
+		assertInt64(t, int64(got[0].Tick), 4)
+		assertInt(t, capped.Values, 7)
+	})
+
+	t.Run("Nil log is empty", func(t *testing.T) {
+		var nilLog *AuditLog
+		nilLog.Record(httptest.NewRequest("GET", "/metrics", nil), 1, nil)
+		assertInt(t, len(nilLog.Query(AuditFilter{})), 0)
+	})
+}
+
+func TestEPHandle_AuditHandler(t *testing.T) {
+	eph := NewEPHandle([]string{"exp", "float", "int"}, []string{"up", "down"})
+	defer eph.Ticker.Stop()
+	mux := eph.SetupMux()
+
+	eph.Advance()
+	for _, target := range []string{"/metrics", "/series/int/up", "/rand/all"} {
+		r := httptest.NewRequest("GET", target, nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		mux.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	served := eph.Snapshot()
+	want, _ := served.Lookup("Metric_int_up")
+
+	tests := []struct {
+		name     string
+		target   string
+		wantCode int
+		entries  int
+	}{
+		{name: "All entries", target: "/audit", wantCode: http.StatusOK, entries: 3},
+		{name: "By endpoint", target: "/audit?endpoint=/series/int/up", wantCode: http.StatusOK, entries: 1},
+		{name: "By series", target: "/audit?series=Metric_int_up", wantCode: http.StatusOK, entries: 2},
+		{name: "By client", target: "/audit?client=192.0.2.1", wantCode: http.StatusOK, entries: 3},
+		{name: "Other client", target: "/audit?client=192.0.2.2", wantCode: http.StatusOK, entries: 0},
+		{name: "Future tick", target: "/audit?since_tick=100", wantCode: http.StatusOK, entries: 0},
+		{name: "Invalid tick", target: "/audit?since_tick=soon", wantCode: http.StatusBadRequest},
+		{name: "Invalid limit", target: "/audit?limit=-1", wantCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.target, nil)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
+			assertStatus(t, w.Code, tt.wantCode)
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+
+			var entries []struct {
+				Tick     uint64            `json:"tick"`
+				Client   string            `json:"client"`
+				Endpoint string            `json:"endpoint"`
+				Values   map[string]string `json:"values"`
+			}
+			err := json.Unmarshal(w.Body.Bytes(), &entries)
+			assertError(t, err, nil)
+			assertInt(t, len(entries), tt.entries)
+
+			// Every response served Metric_int_up from the same snapshot
+			for _, e := range entries {
+				if v, ok := e.Values["Metric_int_up"]; ok && v != want.Value {
+					t.Errorf("Expected served value %s, got %s", want.Value, v)
+				}
+				assertInt64(t, int64(e.Tick), int64(served.Tick))
+			}
+		})
+	}
+}
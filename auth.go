@@ -0,0 +1,65 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// ControlAuth protects endpoints that change state, read endpoints stay open.
+// A bearer token, basic auth, or both may be set; with neither, control is open.
+type ControlAuth struct {
+	Token    string // Accepted as Authorization: Bearer <token>
+	User     string // Accepted with Password as basic auth
+	Password string
+}
+
+// Enabled is true when any credential is configured
+func (ca ControlAuth) Enabled() bool {
+	return ca.Token != "" || ca.User != ""
+}
+
+// Allows checks the request's credentials in constant time
+func (ca ControlAuth) Allows(r *http.Request) bool {
+	if !ca.Enabled() {
+		return true
+	}
+
+	if ca.Token != "" {
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok &&
+			subtle.ConstantTimeCompare([]byte(token), []byte(ca.Token)) == 1 {
+			return true
+		}
+	}
+	if ca.User != "" {
+		if user, password, ok := r.BasicAuth(); ok &&
+			subtle.ConstantTimeCompare([]byte(user), []byte(ca.User)) == 1 &&
+			subtle.ConstantTimeCompare([]byte(password), []byte(ca.Password)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// requireControl wraps a handler that changes state with the handle's ControlAuth
+func (eph *EPHandle) requireControl(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !eph.Auth.Allows(r) {
+			slog.Error("Unauthorized control request",
+				slog.String("method", r.Method),
+				slog.String("request", r.RequestURI),
+				slog.String("remote_addr", r.RemoteAddr))
+
+			if eph.Auth.Token != "" {
+				w.Header().Add("WWW-Authenticate", `Bearer realm="toadlester"`)
+			}
+			if eph.Auth.User != "" {
+				w.Header().Add("WWW-Authenticate", `Basic realm="toadlester"`)
+			}
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestControlAuth_Allows(t *testing.T) {
+	both := ControlAuth{Token: "s3cret", User: "admin", Password: "hunter2"}
+
+	tests := []struct {
+		name   string
+		auth   ControlAuth
+		header func(r *http.Request)
+		want   bool
+	}{
+		{name: "Open without credentials", auth: ControlAuth{}, header: func(r *http.Request) {}, want: true},
+		{name: "Bearer token", auth: both, header: func(r *http.Request) { r.Header.Set("Authorization", "Bearer s3cret") }, want: true},
+		{name: "Wrong token", auth: both, header: func(r *http.Request) { r.Header.Set("Authorization", "Bearer guess") }, want: false},
+		{name: "Basic auth", auth: both, header: func(r *http.Request) { r.SetBasicAuth("admin", "hunter2") }, want: true},
+		{name: "Wrong password", auth: both, header: func(r *http.Request) { r.SetBasicAuth("admin", "guess") }, want: false},
+		{name: "Basic auth when only a token is set", auth: ControlAuth{Token: "s3cret"}, header: func(r *http.Request) { r.SetBasicAuth("", "s3cret") }, want: false},
+		{name: "No credentials", auth: both, header: func(r *http.Request) {}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/reset/INT_SIZE/10", nil)
+			tt.header(r)
+			if got := tt.auth.Allows(r); got != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestEPHandle_ControlEndpoints(t *testing.T) {
+	t.Setenv("INT_SIZE", "")
+	eph := NewEPHandle([]string{"exp", "float", "int"}, []string{"up", "down"})
+	defer eph.Ticker.Stop()
+	eph.Auth = ControlAuth{Token: "s3cret"}
+	mux := eph.SetupMux()
+
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		token    string
+		wantCode int
+	}{
+		{name: "Reset needs POST or PUT", method: "GET", target: "/reset/INT_SIZE/10", token: "s3cret", wantCode: http.StatusMethodNotAllowed},
+		{name: "Reset needs a token", method: "POST", target: "/reset/INT_SIZE/10", wantCode: http.StatusUnauthorized},
+		{name: "Reset with a wrong token", method: "POST", target: "/reset/INT_SIZE/10", token: "guess", wantCode: http.StatusUnauthorized},
+		{name: "Reset with POST", method: "POST", target: "/reset/INT_SIZE/10", token: "s3cret", wantCode: http.StatusOK},
+		{name: "Reset with PUT", method: "PUT", target: "/reset/INT_SIZE/10", token: "s3cret", wantCode: http.StatusOK},
+		{name: "Reads stay open", method: "GET", target: "/metrics", wantCode: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.target, nil)
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
+			assertStatus(t, w.Code, tt.wantCode)
+			if tt.wantCode == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("Expected a WWW-Authenticate header")
+			}
+		})
+	}
+}
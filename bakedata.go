@@ -2,10 +2,14 @@ package main
 
 import (
 	"log/slog"
-	"math/rand/v2"
+	"maps"
+	"math"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
 // CycBuffer is a cyclical shift register
@@ -16,19 +20,29 @@ type CycBuffer struct {
 	Values  []string // Slice of whatever we need for responses
 	MaxSize int      // How big this buffer can be
 	Index   int      // We are at this index in the step buffer
+	Once    bool     // Stop at the last value instead of wrapping
+	History *History // Recently served values, nil when disabled
+	Rand    *Rand    // Source for special values, nil uses the global source
+
+	Specials Specials // Rates of special values, rolled again on each Shift
+	Tail     int      // Digits of special and injected values in the numeric type
+	Special  string   // Served instead of the current value this tick when set
+
+	Injected   string // Served instead of the buffer when set, see Inject
+	InjectHeld bool   // The injected value is kept through the next Shift
 }
 
 // NewShiftCycBuffer creates a series of values based on ENV VAR configurations.
 // These number generators are multiplicative and random
 // to provide some ambiguity within the number series
 // so it isn't always a set of evenly spaced values.
-func NewShiftCycBuffer(maxSize, limit, tail int, mod float64, f, a string) *CycBuffer {
+func NewShiftCycBuffer(maxSize, limit, tail int, mod float64, f, a string, rng *Rand) *CycBuffer {
 	values := make([]string, 0, maxSize)
 
-	saltF := mod * float64(rand.Int32N(int32(limit))+1) // Seed using *_MOD and *_LIMIT (normalized to 1)
-	saltM := rand.Float64() + 0.1                       // SaltMultiplier, internally randomized (normalized to 0.1)
-	saltF *= saltM                                      // Float salt
-	saltI := int(mod) * int(saltF)                      // Int salt
+	saltF := mod * float64(rng.Int32N(int32(limit))+1) // Seed using *_MOD and *_LIMIT (normalized to 1)
+	saltM := rng.Float64() + 0.1                       // SaltMultiplier, internally randomized (normalized to 0.1)
+	saltF *= saltM                                     // Float salt
+	saltI := int(mod) * int(saltF)                     // Int salt
 	if saltI == 0 {
 		saltI = 1
 	}
@@ -87,27 +101,155 @@ func NewShiftCycBuffer(maxSize, limit, tail int, mod float64, f, a string) *CycB
 		Values:  values,
 		MaxSize: maxSize,
 		Index:   0,
+		Rand:    rng,
+		Tail:    tail,
+	}
+}
+
+// Specials are the rates, in percent, at which a series
+// replaces a generated value with a special float value.
+// Rates are checked in field order, so their sum should not exceed 100.
+type Specials struct {
+	NaN   int `json:"nan,omitempty"`   // Not a Number
+	Inf   int `json:"inf,omitempty"`   // Infinity, the sign is chosen at random
+	NZero int `json:"nzero,omitempty"` // Signed zero
+	Neg   int `json:"neg,omitempty"`   // Negated value
+}
+
+// ApplySpecials sets the rates at which served values are replaced with special values.
+// The buffer keeps its values, a special value is rolled for each tick as it is served.
+// Tail is needed to render signed zero in the buffer's numeric type.
+func (cb *CycBuffer) ApplySpecials(sp Specials, tail int) {
+	cb.MU.Lock()
+	defer cb.MU.Unlock()
+
+	cb.Specials = sp
+	cb.Tail = tail
+	cb.Special = cb.rollSpecial(cb.Values[cb.Index])
+}
+
+// rollSpecial picks the special value, if any, served in place of v this tick.
+// Callers must hold the buffer lock.
+func (cb *CycBuffer) rollSpecial(v string) string {
+	sp := cb.Specials
+	if sp == (Specials{}) {
+		return ""
+	}
+
+	roll := cb.Rand.IntN(100)
+	switch {
+	case roll < sp.NaN:
+		return FormatValue(math.NaN(), cb.NType, cb.Tail)
+	case roll < sp.NaN+sp.Inf:
+		sign := 1
+		if cb.Rand.IntN(2) == 0 {
+			sign = -1
+		}
+		return FormatValue(math.Inf(sign), cb.NType, cb.Tail)
+	case roll < sp.NaN+sp.Inf+sp.NZero:
+		return FormatValue(math.Copysign(0, -1), cb.NType, cb.Tail)
+	case roll < sp.NaN+sp.Inf+sp.NZero+sp.Neg:
+		return negateValue(v)
 	}
+	return ""
+}
+
+// served is the value of the buffer this tick: an injected value,
+// then a special value, then the value at the index.
+// Callers must hold the buffer lock.
+func (cb *CycBuffer) served() string {
+	switch {
+	case cb.Injected != "":
+		return cb.Injected
+	case cb.Special != "":
+		return cb.Special
+	}
+	return cb.Values[cb.Index]
+}
+
+// FormatValue renders a float in the requested numeric type.
+// Special values use the Prometheus text spellings (NaN, +Inf, -Inf)
+// regardless of type, since an integer cannot hold them.
+func FormatValue(v float64, f string, tail int) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+
+	switch f {
+	case "exp":
+		return strconv.FormatFloat(v, 'e', tail, 64)
+	case "int":
+		if v == 0 && math.Signbit(v) {
+			return "-0"
+		}
+		return strconv.FormatInt(int64(v), 10)
+	default:
+		return strconv.FormatFloat(v, 'f', tail, 64)
+	}
+}
+
+// Flip the sign of an already formatted value
+func negateValue(v string) string {
+	if strings.HasPrefix(v, "-") {
+		return v[1:]
+	}
+	return "-" + v
 }
 
 // Shift returns the next value in the buffer
 // First increase the Index, wrapping when reaching the full size
-// then return the value at that spot
+// then return the value served at that spot
 func (cb *CycBuffer) Shift() string {
 	cb.MU.Lock()
 	defer cb.MU.Unlock()
 
-	cb.Index = (cb.Index + 1) % len(cb.Values)
-	return cb.Values[cb.Index]
+	if cb.InjectHeld {
+		cb.InjectHeld = false
+	} else {
+		cb.Injected = ""
+	}
+
+	if !cb.Once || cb.Index != len(cb.Values)-1 {
+		cb.Index = (cb.Index + 1) % len(cb.Values)
+	}
+	cb.Special = cb.rollSpecial(cb.Values[cb.Index])
+	return cb.served()
+}
+
+// Replace swaps in the values and specials of a newly built buffer, keeping the position and history.
+// It returns the old values.
+func (cb *CycBuffer) Replace(from *CycBuffer) []string {
+	from.MU.Lock()
+	values, maxSize, specials, tail := from.Values, from.MaxSize, from.Specials, from.Tail
+	from.MU.Unlock()
+
+	cb.MU.Lock()
+	defer cb.MU.Unlock()
+
+	old := cb.Values
+	cb.Values = values
+	cb.MaxSize = maxSize
+	cb.Index = cb.Index % len(cb.Values) // The size may have shrunk
+	cb.Specials = specials
+	cb.Tail = tail
+	cb.Special = cb.rollSpecial(cb.Values[cb.Index])
+	return old
 }
 
 // RandBuffers is the engine for building random data buffers.
 // Each of these can be queried by the endpoint to get well-defined random numbers.
 // It grabs new ones every time to create better randomness.
 func (eph *EPHandle) RandBuffers() {
-	for _, mt := range eph.MTypes {
+	// In name order, so seeded handles draw the same values every run
+	for _, name := range slices.Sorted(maps.Keys(eph.MTypes)) {
+		mt := eph.MTypes[name]
 		mt.MU.Lock()
-		buffer := getRandomizedBuffer(mt.Name, "random")
+		buffer := eph.getRandomizedBuffer(mt.Name, "random")
 		buffer.MU.Lock()
 		eph.MTypes[mt.Name].RandomBuffer = buffer.Values
 		buffer.MU.Unlock()
@@ -120,11 +262,29 @@ func (eph *EPHandle) RandBuffers() {
 func (eph *EPHandle) ShiftBuffers() {
 	// Run a Shift() on all CyclicBuffers
 	// This advances buffer.Index along the algorithm
+	// Series do not advance while frozen by a fault
+	tick := eph.Snapshot().Tick + 1
+	eph.MetricsMU.RLock()
 	for _, mt := range eph.MTypes {
-		for _, buff := range mt.ShiftRegisters {
+		for algo, buff := range mt.ShiftRegisters {
+			if mt.Overrides[algo].Faults.Frozen(tick) {
+				continue
+			}
 			buff.Shift()
 		}
 	}
+
+	// User-defined metrics advance the same way
+	for _, m := range eph.sortedMetrics() {
+		if m.Config.Faults.Frozen(tick) {
+			continue
+		}
+		m.Buffer.Shift()
+		if m.Histogram != nil {
+			m.Histogram.Observe()
+		}
+	}
+	eph.MetricsMU.RUnlock()
 }
 
 // FillEnvVar returns the value of a runtime Environment Variable
@@ -145,7 +305,10 @@ func FillEnvVarInt(ev string, def int) int {
 	if fetch == "" {
 		return def
 	}
+	return parseEnvInt(ev, fetch, def)
+}
 
+func parseEnvInt(ev, fetch string, def int) int {
 	value, err := strconv.Atoi(fetch)
 	if err != nil || value < 0 {
 		slog.Warn("Invalid environment variable " + ev)
@@ -153,3 +316,19 @@ func FillEnvVarInt(ev string, def int) int {
 	}
 	return value
 }
+
+// FillEnvVarDuration returns a runtime Environment Variable as a duration, e.g. 30s
+// It takes the name of the ENV VAR and a default
+func FillEnvVarDuration(ev string, def time.Duration) time.Duration {
+	fetch := os.Getenv(ev)
+	if fetch == "" {
+		return def
+	}
+
+	value, err := time.ParseDuration(fetch)
+	if err != nil || value < 0 {
+		slog.Warn("Invalid environment variable " + ev)
+		return def
+	}
+	return value
+}
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"math"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestNewRandCycBuffer(t *testing.T) {
@@ -22,7 +26,7 @@ func TestNewRandCycBuffer(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			get := NewShiftCycBuffer(tt.size, tt.limit, tt.tail, tt.mod, tt.format, "random")
+			get := NewShiftCycBuffer(tt.size, tt.limit, tt.tail, tt.mod, tt.format, "random", nil)
 			got := get.Values
 			for _, v := range got {
 				// The value is random, it won't be useful to test,
@@ -70,7 +74,7 @@ func TestCycBuffer_ShiftRegister(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			shifter := NewShiftCycBuffer(tt.size, tt.limit, tt.tail, tt.mod, tt.format, tt.algo)
+			shifter := NewShiftCycBuffer(tt.size, tt.limit, tt.tail, tt.mod, tt.format, tt.algo, nil)
 			for i := 0; i < 5; i++ {
 				t.Log(shifter.Values[i])
 				nextIdx := (shifter.Index + 1) % shifter.MaxSize
@@ -102,7 +106,7 @@ func TestCycBuffer_ShiftRandomizer(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			get := NewShiftCycBuffer(tt.size, tt.limit, tt.tail, tt.mod, tt.format, tt.algo)
+			get := NewShiftCycBuffer(tt.size, tt.limit, tt.tail, tt.mod, tt.format, tt.algo, nil)
 			got := get.Values
 			for _, v := range got {
 				vf, err := strconv.ParseFloat(v, 64)
@@ -137,6 +141,29 @@ func TestEPHandle_RandBuffers(t *testing.T) {
 
 }
 
+func TestFillEnvVarDuration(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  time.Duration
+	}{
+		{name: "Returns the default when unset", value: "", want: 5 * time.Second},
+		{name: "Returns a set value", value: "250ms", want: 250 * time.Millisecond},
+		{name: "Returns the default when invalid", value: "soon", want: 5 * time.Second},
+		{name: "Returns the default when negative", value: "-1s", want: 5 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TOAD_WAIT", tt.value)
+			got := FillEnvVarDuration("TOAD_WAIT", 5*time.Second)
+			if got != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
 func TestFillEnvVarInt(t *testing.T) {
 
 	t.Run("returns the set default", func(t *testing.T) {
@@ -201,3 +228,106 @@ func TestFillEnvVar(t *testing.T) {
 		assertStringContains(t, got, want)
 	})
 }
+
+func TestCycBuffer_ApplySpecials(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   string
+		tail     int
+		specials Specials
+		want     []string
+	}{
+		{name: "All NaN float", format: "float", tail: 2, specials: Specials{NaN: 100}, want: []string{"NaN"}},
+		{name: "All NaN int", format: "int", tail: 1, specials: Specials{NaN: 100}, want: []string{"NaN"}},
+		{name: "All Inf exp", format: "exp", tail: 1, specials: Specials{Inf: 100}, want: []string{"+Inf", "-Inf"}},
+		{name: "All signed zero int", format: "int", tail: 1, specials: Specials{NZero: 100}, want: []string{"-0"}},
+		{name: "All signed zero float", format: "float", tail: 2, specials: Specials{NZero: 100}, want: []string{"-0.00"}},
+		{name: "All signed zero exp", format: "exp", tail: 1, specials: Specials{NZero: 100}, want: []string{"-0.0e+00"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buffer := NewShiftCycBuffer(10, 10, tt.tail, 1, tt.format, "up", nil)
+			before := slices.Clone(buffer.Values)
+			buffer.ApplySpecials(tt.specials, tt.tail)
+			for range 20 {
+				if v := buffer.Shift(); !slices.Contains(tt.want, v) {
+					t.Errorf("Expected one of %v, got %s", tt.want, v)
+				}
+			}
+			if !slices.Equal(before, buffer.Values) {
+				t.Errorf("Expected the buffer to keep %v, got %v", before, buffer.Values)
+			}
+		})
+	}
+
+	t.Run("Negates values", func(t *testing.T) {
+		buffer := NewShiftCycBuffer(10, 10, 1, 1, "int", "up", nil)
+		buffer.ApplySpecials(Specials{Neg: 100}, 1)
+		for range 10 {
+			v := buffer.Shift()
+			vi, err := strconv.Atoi(v)
+			assertError(t, err, nil)
+			if vi > 0 || !strings.HasPrefix(v, "-") {
+				t.Errorf("Expected negative value, got %s", v)
+			}
+		}
+	})
+
+	t.Run("Zero rates leave values alone", func(t *testing.T) {
+		buffer := NewShiftCycBuffer(10, 10, 1, 1, "float", "up", nil)
+		buffer.ApplySpecials(Specials{}, 1)
+		for i := range 10 {
+			if v := buffer.Shift(); v != buffer.Values[(i+1)%10] {
+				t.Errorf("Expected %s, got %s", buffer.Values[(i+1)%10], v)
+			}
+		}
+	})
+
+	t.Run("Rates are rolled on every tick", func(t *testing.T) {
+		buffer := NewShiftCycBuffer(10, 10, 1, 1, "int", "up", NewRand(1))
+		buffer.ApplySpecials(Specials{NaN: 5}, 1)
+
+		// Each position serves NaN now and then, so no buffer goes without
+		nans := make(map[int]int)
+		for range 10000 {
+			if buffer.Shift() == "NaN" {
+				nans[buffer.Index]++
+			}
+		}
+		assertInt(t, len(nans), 10)
+		var total int
+		for _, n := range nans {
+			total += n
+		}
+		if total < 350 || total > 650 {
+			t.Errorf("Expected about 5%% of 10000 values to be NaN, got %d", total)
+		}
+	})
+}
+
+func TestFormatValue(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  float64
+		format string
+		tail   int
+		want   string
+	}{
+		{name: "NaN", value: math.NaN(), format: "float", tail: 1, want: "NaN"},
+		{name: "Positive infinity int", value: math.Inf(1), format: "int", tail: 1, want: "+Inf"},
+		{name: "Negative infinity exp", value: math.Inf(-1), format: "exp", tail: 1, want: "-Inf"},
+		{name: "Negative float", value: -1.25, format: "float", tail: 2, want: "-1.25"},
+		{name: "Positive exp", value: 1500, format: "exp", tail: 1, want: "1.5e+03"},
+		{name: "Truncated int", value: 42.9, format: "int", tail: 1, want: "42"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := FormatValue(tt.value, tt.format, tt.tail)
+			if got != tt.want {
+				t.Errorf("Expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"sort"
+)
+
+// Upper bound of series generated by a single template
+const maxTemplateSeries = 1000000
+
+// Template generates a metric for every combination of label values.
+// Static labels from SeriesConfig are added to every series.
+type Template struct {
+	SeriesConfig
+	Cardinality map[string]int `json:"cardinality"`           // Number of values for each generated label
+	Churn       int            `json:"churn,omitempty"`       // Percent of series replaced each tick
+	ChurnLabel  string         `json:"churn_label,omitempty"` // Label given a new value on churn
+	next        int            // Next value used for ChurnLabel
+}
+
+// Validate checks the template's labels and size
+func (tpl *Template) Validate() error {
+	if err := tpl.SeriesConfig.Validate(); err != nil {
+		return err
+	}
+	if len(tpl.Cardinality) == 0 {
+		return fmt.Errorf("template %s has no cardinality", tpl.Name)
+	}
+
+	total := 1
+	for k, n := range tpl.Cardinality {
+		if !labelNameRE.MatchString(k) {
+			return fmt.Errorf("invalid label name %q for template %s", k, tpl.Name)
+		}
+		if _, ok := tpl.Labels[k]; ok {
+			return fmt.Errorf("label %q for template %s is both static and generated", k, tpl.Name)
+		}
+		if n <= 0 {
+			return fmt.Errorf("cardinality of %q for template %s must be positive", k, tpl.Name)
+		}
+		total *= n
+		if total > maxTemplateSeries {
+			return fmt.Errorf("template %s exceeds %d series", tpl.Name, maxTemplateSeries)
+		}
+	}
+
+	if tpl.Churn < 0 || tpl.Churn > 100 {
+		return fmt.Errorf("churn for template %s must be 0-100", tpl.Name)
+	}
+	if tpl.ChurnLabel != "" {
+		if _, ok := tpl.Cardinality[tpl.ChurnLabel]; !ok {
+			return fmt.Errorf("churn label %q for template %s is not generated", tpl.ChurnLabel, tpl.Name)
+		}
+	}
+	return nil
+}
+
+// LabelSets returns every combination of generated label values, e.g.:
+// {"pod": 2, "code": 2} gives pod-0/code-0, pod-0/code-1, pod-1/code-0, pod-1/code-1
+func (tpl *Template) LabelSets() []map[string]string {
+	keys := slices.Sorted(maps.Keys(tpl.Cardinality))
+
+	sets := []map[string]string{maps.Clone(tpl.Labels)}
+	for _, k := range keys {
+		next := make([]map[string]string, 0, len(sets)*tpl.Cardinality[k])
+		for _, set := range sets {
+			for i := 0; i < tpl.Cardinality[k]; i++ {
+				labels := maps.Clone(set)
+				if labels == nil {
+					labels = make(map[string]string)
+				}
+				labels[k] = labelValue(k, i)
+				next = append(next, labels)
+			}
+		}
+		sets = next
+	}
+	return sets
+}
+
+// Generated label values are the label name and a number
+func labelValue(label string, n int) string {
+	return fmt.Sprintf("%s-%d", label, n)
+}
+
+// AddTemplate creates a metric for every label set of the template
+func (eph *EPHandle) AddTemplate(tpl Template) error {
+	if err := tpl.Validate(); err != nil {
+		return err
+	}
+	tpl.rand = eph.Rand
+	tpl.history = eph.HistorySize
+	if tpl.ChurnLabel == "" {
+		tpl.ChurnLabel = slices.Sorted(maps.Keys(tpl.Cardinality))[0]
+	}
+	tpl.next = tpl.Cardinality[tpl.ChurnLabel]
+
+	metrics := make([]*Metric, 0)
+	for _, labels := range tpl.LabelSets() {
+		sc := tpl.SeriesConfig
+		sc.Labels = labels
+		metric, err := NewMetric(sc)
+		if err != nil {
+			return err
+		}
+		metric.Template = tpl.Name
+		metrics = append(metrics, metric)
+	}
+
+	eph.MetricsMU.Lock()
+	for _, m := range metrics {
+		if _, ok := eph.Metrics[m.ID]; ok {
+			eph.MetricsMU.Unlock()
+			return fmt.Errorf("%w: %s", ErrDuplicateMetric, m.ID)
+		}
+	}
+	for _, m := range metrics {
+		eph.Metrics[m.ID] = m
+	}
+	eph.Templates = append(eph.Templates, &tpl)
+	eph.MetricsMU.Unlock()
+	eph.Publish()
+
+	slog.Info("Template generated",
+		slog.String("name", tpl.Name),
+		slog.Int("series", len(metrics)),
+		slog.Int("churn", tpl.Churn))
+
+	return nil
+}
+
+// ChurnSeries replaces a percentage of each template's series with new ones.
+// The replacement has a new value for the churn label, like a rescheduled pod,
+// so the old series disappears and a series never seen before appears.
+func (eph *EPHandle) ChurnSeries() {
+	eph.MetricsMU.Lock()
+	defer eph.MetricsMU.Unlock()
+
+	for _, tpl := range eph.Templates {
+		if tpl.Churn == 0 {
+			continue
+		}
+
+		var members []*Metric
+		for _, m := range eph.Metrics {
+			if m.Template == tpl.Name {
+				members = append(members, m)
+			}
+		}
+		if len(members) == 0 {
+			continue
+		}
+		sort.Slice(members, func(i, j int) bool {
+			return members[i].ID < members[j].ID
+		})
+
+		count := max(len(members)*tpl.Churn/100, 1)
+		for _, i := range eph.Rand.Perm(len(members))[:count] {
+			old := members[i]
+			sc := old.Config
+			sc.Labels = maps.Clone(sc.Labels)
+			sc.Labels[tpl.ChurnLabel] = labelValue(tpl.ChurnLabel, tpl.next)
+			tpl.next++
+
+			metric, err := NewMetric(sc)
+			if err != nil {
+				slog.Error("Could not churn series", slog.String("id", old.ID), slog.Any("error", err))
+				continue
+			}
+			metric.Template = tpl.Name
+
+			delete(eph.Metrics, old.ID)
+			eph.Metrics[metric.ID] = metric
+		}
+
+		slog.Debug("CHURNED TEMPLATE",
+			slog.String("name", tpl.Name),
+			slog.Int("replaced", count))
+	}
+}
+
+// dropEmptyTemplate stops churning a template once its last series is removed.
+// Callers must hold MetricsMU for writing.
+func (eph *EPHandle) dropEmptyTemplate(name string) {
+	for _, m := range eph.Metrics {
+		if m.Template == name {
+			return
+		}
+	}
+	eph.Templates = slices.DeleteFunc(eph.Templates, func(tpl *Template) bool {
+		return tpl.Name == name
+	})
+}
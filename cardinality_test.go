@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestTemplate_LabelSets(t *testing.T) {
+	tpl := Template{
+		SeriesConfig: SeriesConfig{Name: "http_requests", Labels: map[string]string{"job": "toad"}, Type: "int", Algo: "up"},
+		Cardinality:  map[string]int{"pod": 5, "endpoint": 4, "code": 3},
+	}
+
+	sets := tpl.LabelSets()
+	assertInt(t, len(sets), 5*4*3)
+
+	seen := make(map[string]bool)
+	for _, labels := range sets {
+		id := SeriesID(tpl.Name, labels)
+		if seen[id] {
+			t.Errorf("Duplicate label set %s", id)
+		}
+		seen[id] = true
+
+		if labels["job"] != "toad" {
+			t.Errorf("Expected static label job=toad in %s", id)
+		}
+	}
+
+	if !seen[`http_requests{code="code-2",endpoint="endpoint-3",job="toad",pod="pod-4"}`] {
+		t.Errorf("Expected the last label set to be generated")
+	}
+}
+
+func TestTemplate_Validate(t *testing.T) {
+	base := SeriesConfig{Name: "http_requests", Type: "int", Algo: "up"}
+
+	tests := []struct {
+		name    string
+		tpl     Template
+		wantErr bool
+	}{
+		{name: "Valid template", tpl: Template{SeriesConfig: base, Cardinality: map[string]int{"pod": 50}, Churn: 10}},
+		{name: "No cardinality", tpl: Template{SeriesConfig: base}, wantErr: true},
+		{name: "Zero cardinality", tpl: Template{SeriesConfig: base, Cardinality: map[string]int{"pod": 0}}, wantErr: true},
+		{name: "Too many series", tpl: Template{SeriesConfig: base, Cardinality: map[string]int{"a": 1000, "b": 1001}}, wantErr: true},
+		{name: "Churn out of range", tpl: Template{SeriesConfig: base, Cardinality: map[string]int{"pod": 5}, Churn: 101}, wantErr: true},
+		{name: "Unknown churn label", tpl: Template{SeriesConfig: base, Cardinality: map[string]int{"pod": 5}, ChurnLabel: "node"}, wantErr: true},
+		{
+			name: "Static and generated label",
+			tpl: Template{
+				SeriesConfig: SeriesConfig{Name: "http_requests", Labels: map[string]string{"pod": "a"}, Type: "int", Algo: "up"},
+				Cardinality:  map[string]int{"pod": 5},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.tpl.Validate()
+			if tt.wantErr {
+				assertGotError(t, err)
+				return
+			}
+			assertError(t, err, nil)
+		})
+	}
+}
+
+func TestEPHandle_ChurnSeries(t *testing.T) {
+	eph := NewEPHandle([]string{"exp", "float", "int"}, []string{"up", "down"})
+	defer eph.Ticker.Stop()
+
+	err := eph.AddTemplate(Template{
+		SeriesConfig: SeriesConfig{Name: "http_requests", Type: "int", Algo: "up"},
+		Cardinality:  map[string]int{"pod": 10, "code": 5},
+		Churn:        20,
+		ChurnLabel:   "pod",
+	})
+	assertError(t, err, nil)
+	assertInt(t, len(eph.Metrics), 50)
+
+	before := make(map[string]bool)
+	for id := range eph.Metrics {
+		before[id] = true
+	}
+
+	eph.ChurnSeries()
+
+	// The number of series is constant, but 20% of them are new
+	assertInt(t, len(eph.Metrics), 50)
+	var added int
+	for id, m := range eph.Metrics {
+		if !before[id] {
+			added++
+			if m.Template != "http_requests" {
+				t.Errorf("Expected churned series %s to belong to the template", id)
+			}
+		}
+	}
+	assertInt(t, added, 10)
+
+	t.Run("New label values are never reused", func(t *testing.T) {
+		eph.ChurnSeries()
+		assertInt(t, len(eph.Metrics), 50)
+		assertInt(t, eph.Templates[0].next, 10+20)
+	})
+	t.Run("Deleting every series drops the template", func(t *testing.T) {
+		mux := eph.SetupMux()
+		for id := range eph.Metrics {
+			assertStatus(t, serve(t, mux, "DELETE", seriesPath(id), "").Code, http.StatusNoContent)
+		}
+		assertInt(t, len(eph.Templates), 0)
+		eph.Advance()
+	})
+
+	t.Run("A template without series is skipped", func(t *testing.T) {
+		eph.Templates = append(eph.Templates, &Template{SeriesConfig: SeriesConfig{Name: "gone"}, Churn: 50, ChurnLabel: "pod"})
+		eph.ChurnSeries()
+		assertInt(t, len(eph.Metrics), 0)
+	})
+}
@@ -0,0 +1,61 @@
+package main
+
+import "math"
+
+const (
+	secondsPerDay  = 24 * 60 * 60
+	secondsPerWeek = 7 * secondsPerDay
+	defTickSeconds = 60 * 60 // Virtual time covered by a composite tick
+)
+
+// Composite is the sum of a linear trend, daily and weekly cycles, and noise.
+// Every tick covers TickSeconds of virtual time, so an hour per tick
+// fits three weeks of shape into a buffer of 504 values.
+type Composite struct {
+	Base        float64 // Starting level
+	Trend       float64 // Change per virtual day
+	Daily       float64 // Amplitude of the daily cycle
+	Weekly      float64 // Amplitude of the weekly cycle
+	Noise       float64 // Standard deviation of the noise
+	TickSeconds float64 // Virtual seconds per tick
+	Rand        *Rand   // Source of the noise
+}
+
+// NewCompositeCycBuffer creates a series from each component.
+// Cycles start at their midpoint, rising, at virtual midnight on the first day.
+func NewCompositeCycBuffer(maxSize int, c Composite, f string, tail int) *CycBuffer {
+	values := make([]string, 0, maxSize)
+
+	for i := 0; i < maxSize; i++ {
+		t := float64(i) * c.TickSeconds
+		v := c.Base +
+			c.Trend*t/secondsPerDay +
+			c.Daily*math.Sin(2*math.Pi*t/secondsPerDay) +
+			c.Weekly*math.Sin(2*math.Pi*t/secondsPerWeek) +
+			c.Noise*c.Rand.NormFloat64()
+		values = append(values, FormatValue(v, f, tail))
+	}
+
+	return &CycBuffer{
+		NType:   f,
+		MAlgo:   "composite",
+		Values:  values,
+		MaxSize: maxSize,
+		Index:   0,
+		Rand:    c.Rand,
+	}
+}
+
+// composite reads components from the series parameters.
+// The base defaults to Limit * Mod, like the top of the up and down series.
+func (sc *SeriesConfig) composite() Composite {
+	return Composite{
+		Base:        sc.param("base", float64(sc.Limit)*sc.Mod),
+		Trend:       sc.param("trend", 0),
+		Daily:       sc.param("daily", 0),
+		Weekly:      sc.param("weekly", 0),
+		Noise:       sc.param("noise", 0),
+		TickSeconds: sc.param("tick_seconds", defTickSeconds),
+		Rand:        sc.rand,
+	}
+}
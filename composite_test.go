@@ -0,0 +1,106 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNewCompositeCycBuffer(t *testing.T) {
+	tests := []struct {
+		name  string
+		size  int
+		comp  Composite
+		check func(t *testing.T, values []float64)
+	}{
+		{
+			name: "Linear trend",
+			size: 49,
+			comp: Composite{Base: 100, Trend: 24, TickSeconds: 3600},
+			check: func(t *testing.T, values []float64) {
+				// One per virtual hour
+				for i, v := range values {
+					if math.Abs(v-(100+float64(i))) > 0.01 {
+						t.Errorf("Expected %d at %d, got %v", 100+i, i, v)
+					}
+				}
+			},
+		},
+		{
+			name: "Daily cycle",
+			size: 48,
+			comp: Composite{Daily: 10, TickSeconds: 3600},
+			check: func(t *testing.T, values []float64) {
+				// Peak at 6am, trough at 6pm, repeating the next day
+				assertNear(t, values[6], 10)
+				assertNear(t, values[18], -10)
+				assertNear(t, values[30], 10)
+				assertNear(t, values[0], 0)
+			},
+		},
+		{
+			name: "Weekly cycle",
+			size: 168,
+			comp: Composite{Weekly: 50, TickSeconds: 3600},
+			check: func(t *testing.T, values []float64) {
+				assertNear(t, values[42], 50)
+				assertNear(t, values[126], -50)
+			},
+		},
+		{
+			name: "Noise varies around the base",
+			size: 1000,
+			comp: Composite{Base: 10, Noise: 1, TickSeconds: 3600},
+			check: func(t *testing.T, values []float64) {
+				mean := 0.0
+				for _, v := range values {
+					mean += v
+				}
+				mean /= float64(len(values))
+				if math.Abs(mean-10) > 0.2 {
+					t.Errorf("Expected mean near 10, got %v", mean)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buffer := NewCompositeCycBuffer(tt.size, tt.comp, "float", 4)
+			assertInt(t, len(buffer.Values), tt.size)
+			tt.check(t, parsedValues(buffer.Values))
+		})
+	}
+}
+
+func TestSeriesConfig_Composite(t *testing.T) {
+	t.Run("Params fill components", func(t *testing.T) {
+		metric, err := NewMetric(SeriesConfig{
+			Name: "traffic", Type: "int", Algo: "composite", Size: 504,
+			Params: map[string]float64{"base": 1000, "trend": 10, "daily": 200, "weekly": 100, "noise": 5},
+		})
+		assertError(t, err, nil)
+		assertInt(t, len(metric.Buffer.Values), 504)
+	})
+
+	t.Run("Base defaults to limit times mod", func(t *testing.T) {
+		sc := SeriesConfig{Name: "flat", Type: "float", Algo: "composite", Limit: 20, Mod: 2}
+		comp := sc.composite()
+		assertNear(t, comp.Base, 40)
+		assertNear(t, comp.TickSeconds, defTickSeconds)
+	})
+
+	t.Run("Rejects unknown params", func(t *testing.T) {
+		_, err := NewMetric(SeriesConfig{Name: "traffic", Type: "int", Algo: "composite", Params: map[string]float64{"yearly": 1}})
+		assertGotError(t, err)
+	})
+
+	t.Run("Rejects params for other algorithms", func(t *testing.T) {
+		_, err := NewMetric(SeriesConfig{Name: "traffic", Type: "int", Algo: "up", Params: map[string]float64{"daily": 1}})
+		assertGotError(t, err)
+	})
+
+	t.Run("Rejects non-positive tick seconds", func(t *testing.T) {
+		_, err := NewMetric(SeriesConfig{Name: "traffic", Type: "int", Algo: "composite", Params: map[string]float64{"tick_seconds": 0}})
+		assertGotError(t, err)
+	})
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ControlStatus is the state of the tick loop
+type ControlStatus struct {
+	Paused   bool   `json:"paused"`
+	Tick     uint64 `json:"tick"`
+	Interval string `json:"interval"`
+}
+
+// Injection is a value served by a series in place of its buffer
+type Injection struct {
+	ID    string `json:"id"`
+	Value string `json:"value"`
+}
+
+// Inject serves value in place of the buffer right away and through the next tick,
+// formatted like the buffer's own values. It returns the value as served.
+func (cb *CycBuffer) Inject(value float64) string {
+	cb.MU.Lock()
+	defer cb.MU.Unlock()
+	cb.Injected = FormatValue(value, cb.NType, cb.Tail)
+	cb.InjectHeld = true
+	return cb.Injected
+}
+
+func (eph *EPHandle) status() ControlStatus {
+	return ControlStatus{
+		Paused:   eph.Paused.Load(),
+		Tick:     eph.Snapshot().Tick,
+		Interval: TickInterval.String(),
+	}
+}
+
+func (eph *EPHandle) writeStatus(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(eph.status())
+}
+
+// ControlHandler returns whether ticks are paused, and the current tick
+func (eph *EPHandle) ControlHandler(w http.ResponseWriter, r *http.Request) {
+	eph.writeStatus(w)
+}
+
+// PauseHandler stops buffers advancing on each tick, series keep their current values
+func (eph *EPHandle) PauseHandler(w http.ResponseWriter, r *http.Request) {
+	eph.Paused.Store(true)
+	eph.LastTick.Store(time.Now().UnixNano())
+	slog.Info("Ticks paused",
+		slog.String("request", r.RequestURI),
+		slog.String("remote_addr", r.RemoteAddr))
+	eph.writeStatus(w)
+}
+
+// ResumeHandler advances buffers on each tick again.
+// The pause is not counted as missed ticks, the next tick is measured from now.
+func (eph *EPHandle) ResumeHandler(w http.ResponseWriter, r *http.Request) {
+	eph.LastTick.Store(time.Now().UnixNano())
+	eph.Paused.Store(false)
+	slog.Info("Ticks resumed",
+		slog.String("request", r.RequestURI),
+		slog.String("remote_addr", r.RemoteAddr))
+	eph.writeStatus(w)
+}
+
+// StepHandler advances every buffer one tick, paused or not
+func (eph *EPHandle) StepHandler(w http.ResponseWriter, r *http.Request) {
+	eph.Step()
+	slog.Info("Stepped",
+		slog.String("request", r.RequestURI),
+		slog.String("remote_addr", r.RemoteAddr),
+		slog.Uint64("tick", eph.Snapshot().Tick))
+	eph.writeStatus(w)
+}
+
+// InjectHandler serves a chosen value for a built-in or user-defined series,
+// e.g. a spike, right away and through the next tick
+func (eph *EPHandle) InjectHandler(w http.ResponseWriter, r *http.Request) {
+	var inj Injection
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSeriesBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&inj); err != nil {
+		slog.Error("Invalid injection", slog.Any("error", err))
+		http.Error(w, "Invalid injection: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	value, err := strconv.ParseFloat(inj.Value, 64)
+	if err != nil {
+		slog.Error("Invalid injection value: " + inj.Value)
+		http.Error(w, "Invalid injection value: "+inj.Value, http.StatusBadRequest)
+		return
+	}
+
+	eph.MetricsMU.RLock()
+	_, buff, m := eph.lookupSeries(inj.ID)
+	d, sm := eph.lookupComputed(inj.ID)
+	code := http.StatusBadRequest
+	switch {
+	case d != nil || sm != nil:
+		err = fmt.Errorf("derived and state series %s cannot take a value", inj.ID)
+	case m != nil && m.Histogram != nil:
+		err = fmt.Errorf("histogram %s cannot take a value", inj.ID)
+	case m != nil:
+		inj.Value = m.Buffer.Inject(value)
+	case buff != nil:
+		inj.Value = buff.Inject(value)
+	default:
+		err = fmt.Errorf("invalid series: %s", inj.ID)
+		code = http.StatusNotFound
+	}
+	eph.MetricsMU.RUnlock()
+
+	if err != nil {
+		slog.Error("Could not inject", slog.Any("error", err))
+		http.Error(w, "Could not inject: "+err.Error(), code)
+		return
+	}
+
+	// Serve the value without waiting for the next tick
+	eph.Publish()
+
+	slog.Info("Injected",
+		slog.String("request", r.RequestURI),
+		slog.String("remote_addr", r.RemoteAddr),
+		slog.String("series", inj.ID),
+		slog.String("value", inj.Value))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(inj)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEPHandle_PauseAndStep(t *testing.T) {
+	eph := NewEPHandle([]string{"exp", "float", "int"}, []string{"up", "down"})
+	defer eph.Ticker.Stop()
+	target := NewTargetEPHandle([]string{"int"}, []string{"up"}, NewEnv(nil), nil)
+	eph.Mount("/web-1", target)
+	mux := eph.SetupMux()
+
+	status := func(w *httptest.ResponseRecorder) ControlStatus {
+		t.Helper()
+		var s ControlStatus
+		assertError(t, json.NewDecoder(w.Body).Decode(&s), nil)
+		return s
+	}
+
+	t.Run("Pauses", func(t *testing.T) {
+		w := serve(t, mux, "POST", "/control/pause", "")
+		assertStatus(t, w.Code, http.StatusOK)
+		if !status(w).Paused || !eph.Paused.Load() {
+			t.Error("Expected ticks to be paused")
+		}
+	})
+
+	t.Run("Steps while paused", func(t *testing.T) {
+		before := eph.Snapshot().Tick
+		w := serve(t, mux, "POST", "/control/step", "")
+		assertStatus(t, w.Code, http.StatusOK)
+		if got := status(w).Tick; got != before+1 {
+			t.Errorf("Expected tick %d, got %d", before+1, got)
+		}
+	})
+
+	t.Run("Stays healthy while paused", func(t *testing.T) {
+		eph.LastTick.Store(time.Now().Add(-time.Hour).UnixNano())
+		assertStatus(t, serve(t, mux, "GET", "/healthz", "").Code, http.StatusOK)
+	})
+
+	t.Run("Paused targets do not advance with the main handle", func(t *testing.T) {
+		assertStatus(t, serve(t, mux, "POST", "/web-1/control/pause", "").Code, http.StatusOK)
+		before := target.Snapshot().Tick
+		eph.Advance()
+		if target.Snapshot().Tick != before {
+			t.Errorf("Expected the paused target to stay at tick %d, got %d", before, target.Snapshot().Tick)
+		}
+	})
+
+	t.Run("Resumes", func(t *testing.T) {
+		w := serve(t, mux, "POST", "/control/resume", "")
+		assertStatus(t, w.Code, http.StatusOK)
+		if status(w).Paused {
+			t.Error("Expected ticks to resume")
+		}
+		w = serve(t, mux, "GET", "/control", "")
+		assertStatus(t, w.Code, http.StatusOK)
+		if s := status(w); s.Paused || s.Interval != TickInterval.String() {
+			t.Errorf("Expected running every %s, got %+v", TickInterval, s)
+		}
+	})
+
+	t.Run("Pauses and steps are not missed ticks", func(t *testing.T) {
+		missed := eph.Internal.MissedTicks.Load()
+		assertStatus(t, serve(t, mux, "POST", "/control/pause", "").Code, http.StatusOK)
+		eph.LastTick.Store(time.Now().Add(-time.Hour).UnixNano()) // A long pause
+		assertStatus(t, serve(t, mux, "POST", "/control/step", "").Code, http.StatusOK)
+		assertStatus(t, serve(t, mux, "POST", "/control/resume", "").Code, http.StatusOK)
+		eph.Advance()
+		assertInt64(t, int64(eph.Internal.MissedTicks.Load()), int64(missed))
+	})
+
+	t.Run("Controls need POST and credentials", func(t *testing.T) {
+		assertStatus(t, serve(t, mux, "GET", "/control/step", "").Code, http.StatusMethodNotAllowed)
+
+		eph.Auth = ControlAuth{Token: "s3cret"}
+		defer func() { eph.Auth = ControlAuth{} }()
+		for _, path := range []string{"/control/pause", "/control/resume", "/control/step", "/control/inject"} {
+			assertStatus(t, serve(t, mux, "POST", path, "").Code, http.StatusUnauthorized)
+		}
+	})
+}
+
+func TestEPHandle_InjectHandler(t *testing.T) {
+	eph := NewEPHandle([]string{"exp", "float", "int"}, []string{"up", "down"})
+	defer eph.Ticker.Stop()
+	assertError(t, eph.AddMetric(SeriesConfig{Name: "latency", Type: "float", Algo: "lognormal", Buckets: []float64{0.1, 1}}), nil)
+	mux := eph.SetupMux()
+
+	served := func() string {
+		sample, _ := eph.Snapshot().Lookup("Metric_int_up")
+		return sample.Value
+	}
+
+	t.Run("Serves the value right away and through the next tick", func(t *testing.T) {
+		w := serve(t, mux, "POST", "/control/inject", `{"id": "Metric_int_up", "value": "1e9"}`)
+		assertStatus(t, w.Code, http.StatusOK)
+		assertStringContains(t, w.Body.String(), `"value":"1000000000"`)
+		if served() != "1000000000" {
+			t.Errorf("Expected 1000000000 right away, got %s", served())
+		}
+
+		eph.Advance()
+		if served() != "1000000000" {
+			t.Errorf("Expected 1000000000 through the next tick, got %s", served())
+		}
+		w = serve(t, mux, "GET", "/metrics", "")
+		assertStringContains(t, w.Body.String(), "Metric_int_up: 1000000000")
+
+		eph.Advance()
+		if served() == "1000000000" {
+			t.Error("Expected the buffer to be served again")
+		}
+	})
+
+	t.Run("Formats the value like the series", func(t *testing.T) {
+		assertError(t, eph.AddMetric(SeriesConfig{Name: "load", Type: "float", Algo: "up", Tail: 2}), nil)
+		w := serve(t, mux, "POST", "/control/inject", `{"id": "load", "value": "3.14159"}`)
+		assertStatus(t, w.Code, http.StatusOK)
+		sample, _ := eph.Snapshot().Lookup("load")
+		if sample.Value != "3.14" {
+			t.Errorf("Expected 3.14, got %s", sample.Value)
+		}
+	})
+
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+	}{
+		{name: "Special value", body: `{"id": "Metric_float_down", "value": "NaN"}`, wantCode: http.StatusOK},
+		{name: "Not a number", body: `{"id": "Metric_int_up", "value": "lots"}`, wantCode: http.StatusBadRequest},
+		{name: "Histogram", body: `{"id": "latency", "value": "1"}`, wantCode: http.StatusBadRequest},
+		{name: "Unknown series", body: `{"id": "toad", "value": "1"}`, wantCode: http.StatusNotFound},
+		{name: "Unknown field", body: `{"id": "Metric_int_up", "value": "1", "ticks": 3}`, wantCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(t, mux, "POST", "/control/inject", tt.body)
+			assertStatus(t, w.Code, tt.wantCode)
+			if tt.wantCode != http.StatusOK && !strings.Contains(w.Body.String(), "nject") {
+				t.Errorf("Expected an injection error, got %s", w.Body.String())
+			}
+		})
+	}
+}
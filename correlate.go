@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+)
+
+// GroupConfig describes series generated together with known relationships.
+// Every member follows the driver with a target correlation and lag,
+// so the ground truth for root-cause analysis is the configuration itself.
+type GroupConfig struct {
+	Name    string         `json:"name"`
+	Size    int            `json:"size,omitempty"` // Values in every buffer of the group
+	Driver  SeriesConfig   `json:"driver"`         // Series the members follow
+	Members []MemberConfig `json:"members"`
+
+	rand    *Rand // Source of the walks and noise, set by the handle
+	history int   // Values kept in the history of each series, set by the handle
+}
+
+// MemberConfig is a series that follows the group's driver.
+// Its shape is the driver's, Lag ticks later, mixed with its own noise
+// so the Pearson coefficient with the lagged driver is exactly Correlation.
+type MemberConfig struct {
+	SeriesConfig
+	Correlation float64 `json:"correlation"`   // -1 to 1
+	Lag         int     `json:"lag,omitempty"` // Ticks behind the driver
+}
+
+// Validate checks the group and every member
+func (g *GroupConfig) Validate() error {
+	if g.Size < 3 {
+		return fmt.Errorf("group %s needs a size of at least 3", g.Name)
+	}
+	if len(g.Members) == 0 {
+		return fmt.Errorf("group %s has no members", g.Name)
+	}
+
+	driver := g.Driver
+	if driver.Algo == "" || driver.Algo == "walk" {
+		driver.Algo = "random" // Only used to validate the rest
+	}
+	if err := driver.Validate(); err != nil {
+		return fmt.Errorf("group %s driver: %w", g.Name, err)
+	}
+
+	for _, m := range g.Members {
+		member := m.SeriesConfig
+		member.Algo = "random" // Members have no algorithm of their own
+		if err := member.Validate(); err != nil {
+			return fmt.Errorf("group %s member: %w", g.Name, err)
+		}
+		if m.Correlation < -1 || m.Correlation > 1 {
+			return fmt.Errorf("correlation of %s must be -1 to 1", m.Name)
+		}
+		if m.Lag < 0 || m.Lag >= g.Size {
+			return fmt.Errorf("lag of %s must be 0 to %d", m.Name, g.Size-1)
+		}
+	}
+	return nil
+}
+
+// Build generates the driver and member metrics
+func (g *GroupConfig) Build() ([]*Metric, error) {
+	if err := g.Validate(); err != nil {
+		return nil, err
+	}
+
+	driver := g.Driver
+	driver.Size = g.Size
+	driver.rand = g.rand
+	driver.history = g.history
+	driver.applyDefaults()
+
+	// The driver is a smooth random walk, or the shape of an algorithm
+	var latent []float64
+	if driver.Algo == "" || driver.Algo == "walk" {
+		driver.Algo = "walk"
+		latent = randomWalk(g.Size, g.rand)
+	} else {
+		buffer, err := driver.NewBuffer()
+		if err != nil {
+			return nil, fmt.Errorf("group %s driver: %w", g.Name, err)
+		}
+		for _, v := range buffer.Values {
+			latent = append(latent, parseSampleValue(v))
+		}
+	}
+	if len(latent) != g.Size {
+		return nil, fmt.Errorf("group %s driver has %d values, want %d", g.Name, len(latent), g.Size)
+	}
+	if !standardize(latent) {
+		return nil, fmt.Errorf("group %s driver does not vary", g.Name)
+	}
+
+	metrics := []*Metric{newGroupMetric(g.Name, driver, latent)}
+
+	for _, m := range g.Members {
+		// Shift the driver so member[i] follows driver[i-lag], wrapping like the buffer
+		lagged := make([]float64, g.Size)
+		for i := range lagged {
+			lagged[i] = latent[(i-m.Lag+g.Size)%g.Size]
+		}
+
+		// Noise that is uncorrelated with the lagged driver
+		noise := randomWalk(g.Size, g.rand)
+		standardize(noise)
+		dot := 0.0
+		for i := range noise {
+			dot += noise[i] * lagged[i]
+		}
+		for i := range noise {
+			noise[i] -= dot / float64(g.Size) * lagged[i]
+		}
+		if !standardize(noise) {
+			return nil, fmt.Errorf("group %s could not generate noise for %s", g.Name, m.Name)
+		}
+
+		rho := m.Correlation
+		values := make([]float64, g.Size)
+		for i := range values {
+			values[i] = rho*lagged[i] + math.Sqrt(1-rho*rho)*noise[i]
+		}
+
+		sc := m.SeriesConfig
+		sc.Algo = "correlated"
+		sc.rand = g.rand
+		sc.history = g.history
+		sc.Size = g.Size
+		sc.applyDefaults()
+		metrics = append(metrics, newGroupMetric(g.Name, sc, values))
+	}
+
+	return metrics, nil
+}
+
+// newGroupMetric scales standardized values into 0 to Limit * Mod.
+// Scaling is linear, so it does not change any correlation,
+// but rounding to the type and tail moves it by about the step over the range.
+// Specials are rolled as values are served and leave the buffer untouched.
+func newGroupMetric(group string, sc SeriesConfig, latent []float64) *Metric {
+	lo, hi := latent[0], latent[0]
+	for _, v := range latent {
+		lo, hi = math.Min(lo, v), math.Max(hi, v)
+	}
+
+	top := float64(sc.Limit) * sc.Mod
+	values := make([]string, len(latent))
+	for i, v := range latent {
+		scaled := 0.0
+		if hi > lo {
+			scaled = (v - lo) / (hi - lo) * top
+		}
+		values[i] = FormatValue(scaled, sc.Type, sc.Tail)
+	}
+
+	buffer := &CycBuffer{
+		NType:   sc.Type,
+		MAlgo:   sc.Algo,
+		Values:  values,
+		MaxSize: len(values),
+		Index:   0,
+		Rand:    sc.rand,
+		History: NewHistory(sc.history),
+	}
+	buffer.ApplySpecials(sc.Specials, sc.Tail)
+
+	return &Metric{
+		ID:     SeriesID(sc.Name, sc.Labels),
+		Config: sc,
+		Buffer: buffer,
+		Group:  group,
+	}
+}
+
+// randomWalk is a smooth autoregressive series around zero
+func randomWalk(size int, rng *Rand) []float64 {
+	walk := make([]float64, size)
+	walk[0] = rng.NormFloat64()
+	for i := 1; i < size; i++ {
+		walk[i] = 0.8*walk[i-1] + 0.6*rng.NormFloat64()
+	}
+	return walk
+}
+
+// standardize shifts and scales values to a mean of 0 and deviation of 1,
+// returning false when the values do not vary
+func standardize(values []float64) bool {
+	n := float64(len(values))
+	mean := 0.0
+	for _, v := range values {
+		mean += v
+	}
+	mean /= n
+
+	variance := 0.0
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	sd := math.Sqrt(variance / n)
+	if sd == 0 || math.IsNaN(sd) {
+		return false
+	}
+
+	for i := range values {
+		values[i] = (values[i] - mean) / sd
+	}
+	return true
+}
+
+// AddGroup creates the metrics of a correlated group
+func (eph *EPHandle) AddGroup(g GroupConfig) error {
+	g.rand = eph.Rand
+	g.history = eph.HistorySize
+	metrics, err := g.Build()
+	if err != nil {
+		return err
+	}
+
+	eph.MetricsMU.Lock()
+	for _, m := range metrics {
+		if _, ok := eph.Metrics[m.ID]; ok {
+			eph.MetricsMU.Unlock()
+			return fmt.Errorf("%w: %s", ErrDuplicateMetric, m.ID)
+		}
+	}
+	for _, m := range metrics {
+		eph.Metrics[m.ID] = m
+	}
+	eph.MetricsMU.Unlock()
+	eph.Publish()
+
+	slog.Info("Group generated",
+		slog.String("name", g.Name),
+		slog.String("driver", metrics[0].ID),
+		slog.Int("members", len(g.Members)))
+
+	return nil
+}
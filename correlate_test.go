@@ -0,0 +1,183 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"testing"
+)
+
+// Pearson coefficient of two series
+func pearson(x, y []float64) float64 {
+	xs, ys := append([]float64(nil), x...), append([]float64(nil), y...)
+	standardize(xs)
+	standardize(ys)
+	sum := 0.0
+	for i := range xs {
+		sum += xs[i] * ys[i]
+	}
+	return sum / float64(len(xs))
+}
+
+func parsedValues(values []string) []float64 {
+	parsed := make([]float64, len(values))
+	for i, v := range values {
+		parsed[i] = parseSampleValue(v)
+	}
+	return parsed
+}
+
+func TestGroupConfig_Build(t *testing.T) {
+	// Rounding to the type and tail moves the correlation by about the step over the range
+	tests := []struct {
+		name        string
+		driverAlgo  string
+		member      SeriesConfig
+		correlation float64
+		lag         int
+		tolerance   float64
+	}{
+		{name: "Strong positive with lag", driverAlgo: "walk", member: SeriesConfig{Type: "float", Tail: 8, Limit: 5}, correlation: 0.9, lag: 3, tolerance: 0.001},
+		{name: "Negative without lag", driverAlgo: "walk", member: SeriesConfig{Type: "float", Tail: 8, Limit: 5}, correlation: -0.6, lag: 0, tolerance: 0.001},
+		{name: "Uncorrelated", driverAlgo: "", member: SeriesConfig{Type: "float", Tail: 8, Limit: 5}, correlation: 0, lag: 1, tolerance: 0.001},
+		{name: "Identical shape", driverAlgo: "walk", member: SeriesConfig{Type: "float", Tail: 8, Limit: 5}, correlation: 1, lag: 2, tolerance: 0.001},
+		{name: "Ramp driver", driverAlgo: "up", member: SeriesConfig{Type: "float", Tail: 8, Limit: 5}, correlation: 0.8, lag: 3, tolerance: 0.001},
+		{name: "Int member", driverAlgo: "walk", member: SeriesConfig{Type: "int", Limit: 100}, correlation: 0.7, lag: 2, tolerance: 0.01},
+		{name: "Low-tail member", driverAlgo: "walk", member: SeriesConfig{Type: "float", Tail: 1, Limit: 5}, correlation: -0.8, lag: 1, tolerance: 0.02},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			member := tt.member
+			member.Name = "latency_seconds"
+			g := GroupConfig{
+				Name:   "checkout",
+				Size:   200,
+				Driver: SeriesConfig{Name: "request_rate", Type: "float", Algo: tt.driverAlgo, Tail: 8, Limit: 100},
+				Members: []MemberConfig{{
+					SeriesConfig: member,
+					Correlation:  tt.correlation,
+					Lag:          tt.lag,
+				}},
+			}
+			metrics, err := g.Build()
+			assertError(t, err, nil)
+			assertInt(t, len(metrics), 2)
+
+			driver := parsedValues(metrics[0].Buffer.Values)
+			values := parsedValues(metrics[1].Buffer.Values)
+
+			lagged := make([]float64, len(driver))
+			for i := range lagged {
+				lagged[i] = driver[(i-tt.lag+len(driver))%len(driver)]
+			}
+
+			got := pearson(lagged, values)
+			if math.Abs(got-tt.correlation) > tt.tolerance {
+				t.Errorf("Expected correlation %v, got %v", tt.correlation, got)
+			}
+
+			for _, v := range values {
+				if v < 0 || v > float64(member.Limit) {
+					t.Errorf("Expected member within 0 to %d, got %v", member.Limit, v)
+				}
+			}
+		})
+	}
+}
+
+func TestGroupConfig_Validate(t *testing.T) {
+	driver := SeriesConfig{Name: "request_rate", Type: "float"}
+	member := SeriesConfig{Name: "latency_seconds", Type: "float"}
+
+	tests := []struct {
+		name  string
+		group GroupConfig
+	}{
+		{name: "Too small", group: GroupConfig{Size: 2, Driver: driver, Members: []MemberConfig{{SeriesConfig: member}}}},
+		{name: "No members", group: GroupConfig{Size: 10, Driver: driver}},
+		{name: "Correlation out of range", group: GroupConfig{Size: 10, Driver: driver, Members: []MemberConfig{{SeriesConfig: member, Correlation: 1.5}}}},
+		{name: "Lag out of range", group: GroupConfig{Size: 10, Driver: driver, Members: []MemberConfig{{SeriesConfig: member, Lag: 10}}}},
+		{name: "Invalid driver", group: GroupConfig{Size: 10, Driver: SeriesConfig{Name: "bad-name", Type: "float"}, Members: []MemberConfig{{SeriesConfig: member}}}},
+		{name: "Invalid member type", group: GroupConfig{Size: 10, Driver: driver, Members: []MemberConfig{{SeriesConfig: SeriesConfig{Name: "x", Type: "hex"}}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.group.Build()
+			assertGotError(t, err)
+		})
+	}
+
+	t.Run("Flat driver does not vary", func(t *testing.T) {
+		g := GroupConfig{Size: 10, Driver: SeriesConfig{Name: "flat", Type: "int", Algo: "random"}, Members: []MemberConfig{{SeriesConfig: member}}}
+		_, err := g.Build()
+		assertGotError(t, err)
+	})
+}
+
+func TestEPHandle_AddGroup(t *testing.T) {
+	eph := NewEPHandle([]string{"exp", "float", "int"}, []string{"up", "down"})
+	defer eph.Ticker.Stop()
+
+	err := eph.AddGroup(GroupConfig{
+		Name:   "checkout",
+		Size:   50,
+		Driver: SeriesConfig{Name: "request_rate", Type: "int", Limit: 1000},
+		Members: []MemberConfig{
+			{SeriesConfig: SeriesConfig{Name: "latency_seconds", Labels: map[string]string{"quantile": "0.99"}, Type: "float", Tail: 3}, Correlation: 0.9, Lag: 3},
+			{SeriesConfig: SeriesConfig{Name: "error_rate", Type: "float", Tail: 3}, Correlation: 0.5},
+		},
+	})
+	assertError(t, err, nil)
+
+	snap := eph.Snapshot()
+	for _, id := range []string{"request_rate", `latency_seconds{quantile="0.99"}`, "error_rate"} {
+		if _, ok := snap.Lookup(id); !ok {
+			t.Errorf("Expected %s in the snapshot", id)
+		}
+	}
+
+	t.Run("Members shift in lockstep", func(t *testing.T) {
+		eph.Advance()
+		eph.Advance()
+		for _, m := range eph.Metrics {
+			assertInt(t, m.Buffer.Index, 2)
+		}
+	})
+
+	t.Run("Members cannot be patched", func(t *testing.T) {
+		mux := eph.SetupMux()
+		for _, id := range []string{"request_rate", "error_rate"} {
+			w := serve(t, mux, "PATCH", seriesPath(id), `{"limit": 10}`)
+			assertStatus(t, w.Code, http.StatusBadRequest)
+			assertStringContains(t, w.Body.String(), "group checkout cannot be changed")
+		}
+
+		w := serve(t, mux, "GET", seriesPath("error_rate"), "")
+		assertStatus(t, w.Code, http.StatusOK)
+		assertStringContains(t, w.Body.String(), `"group":"checkout"`)
+	})
+
+	t.Run("Specials are served", func(t *testing.T) {
+		err := eph.AddGroup(GroupConfig{
+			Name:    "cache",
+			Size:    10,
+			Driver:  SeriesConfig{Name: "cache_requests", Type: "int", Limit: 100, Specials: Specials{Inf: 100}},
+			Members: []MemberConfig{{SeriesConfig: SeriesConfig{Name: "cache_misses", Type: "float", Tail: 2, Specials: Specials{NaN: 100}}, Correlation: 0.5}},
+		})
+		assertError(t, err, nil)
+
+		for range 3 {
+			eph.Advance()
+			snap := eph.Snapshot()
+			driver, _ := snap.Lookup("cache_requests")
+			member, _ := snap.Lookup("cache_misses")
+			if driver.Value != "+Inf" && driver.Value != "-Inf" {
+				t.Errorf("Expected the driver to serve Inf, got %s", driver.Value)
+			}
+			if member.Value != "NaN" {
+				t.Errorf("Expected the member to serve NaN, got %s", member.Value)
+			}
+		}
+	})
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// DerivedConfig describes a metric calculated from other series every tick.
+// Expressions use + - * / and parentheses over numbers and series, where a series is
+// a built-in type/algo (int/up) or any series ID (http_requests{code="500"}).
+// Window functions avg, min and max take a series and a number of ticks, e.g.: avg(float/up, 5)
+type DerivedConfig struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Expr   string            `json:"expr"`
+	Type   string            `json:"type,omitempty"` // Numeric Type of the result, float by default
+	Tail   int               `json:"tail,omitempty"` // Digits after the point, 1 for float results by default
+}
+
+// Derived is a metric calculated from an expression
+type Derived struct {
+	ID      string
+	Config  DerivedConfig
+	Root    exprNode
+	Refs    []string // Series IDs used by the expression
+	History *History
+}
+
+// exprNode is one step of a parsed expression
+type exprNode interface {
+	eval(snap *Snapshot) float64
+}
+
+type numberNode float64
+
+type refNode string // Series ID
+
+type negNode struct {
+	expr exprNode
+}
+
+type binaryNode struct {
+	op          byte
+	left, right exprNode
+}
+
+type windowNode struct {
+	fn    string
+	ref   string
+	ticks int
+}
+
+func (n numberNode) eval(_ *Snapshot) float64 {
+	return float64(n)
+}
+
+// Series that are missing or do not parse evaluate to NaN
+func (n refNode) eval(snap *Snapshot) float64 {
+	sample, ok := snap.Lookup(string(n))
+	if !ok {
+		return math.NaN()
+	}
+	return parseSampleValue(sample.Value)
+}
+
+func (n negNode) eval(snap *Snapshot) float64 {
+	return -n.expr.eval(snap)
+}
+
+func (n binaryNode) eval(snap *Snapshot) float64 {
+	l, r := n.left.eval(snap), n.right.eval(snap)
+	switch n.op {
+	case '+':
+		return l + r
+	case '-':
+		return l - r
+	case '*':
+		return l * r
+	default:
+		return l / r
+	}
+}
+
+// Window functions read the series history, which includes the current tick
+func (n windowNode) eval(snap *Snapshot) float64 {
+	sample, ok := snap.Lookup(n.ref)
+	if !ok {
+		return math.NaN()
+	}
+
+	var points []Point
+	if sample.History != nil {
+		points = sample.History.Last(n.ticks)
+	}
+	if len(points) == 0 {
+		points = []Point{{Value: sample.Value}}
+	}
+
+	result := parseSampleValue(points[0].Value)
+	for _, p := range points[1:] {
+		v := parseSampleValue(p.Value)
+		switch n.fn {
+		case "avg":
+			result += v
+		case "min":
+			result = math.Min(result, v)
+		case "max":
+			result = math.Max(result, v)
+		}
+	}
+	if n.fn == "avg" {
+		result /= float64(len(points))
+	}
+	return result
+}
+
+func parseSampleValue(v string) float64 {
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return math.NaN()
+	}
+	return f
+}
+
+// NewDerived parses the expression of a derived metric
+func NewDerived(dc DerivedConfig) (*Derived, error) {
+	sc := SeriesConfig{Name: dc.Name, Labels: dc.Labels, Type: dc.Type, Algo: "up", Tail: dc.Tail}
+	if sc.Type == "" {
+		sc.Type = "float"
+	}
+	if err := sc.Validate(); err != nil {
+		return nil, err
+	}
+	dc.Type = sc.Type
+	if dc.Type == "float" && dc.Tail == 0 {
+		dc.Tail = defTail // Like float series, so a ratio is not rounded to a whole number
+	}
+
+	p := &exprParser{input: dc.Expr}
+	root, err := p.parse()
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression for %s: %w", dc.Name, err)
+	}
+
+	return &Derived{
+		ID:     SeriesID(dc.Name, dc.Labels),
+		Config: dc,
+		Root:   root,
+		Refs:   p.refs,
+	}, nil
+}
+
+// Sample evaluates the expression against a snapshot
+func (d *Derived) Sample(snap *Snapshot) Sample {
+	return Sample{
+		ID:      d.ID,
+		Name:    d.Config.Name,
+		Labels:  d.Config.Labels,
+		NType:   d.Config.Type,
+		MAlgo:   "derived",
+		Value:   FormatValue(d.Root.eval(snap), d.Config.Type, d.Config.Tail),
+		History: d.History,
+	}
+}
+
+// AddDerived adds a derived metric, every series it uses must exist
+func (eph *EPHandle) AddDerived(dc DerivedConfig) error {
+	derived, err := NewDerived(dc)
+	if err != nil {
+		return err
+	}
+	derived.History = NewHistory(eph.HistorySize)
+
+	snap := eph.Snapshot()
+	if _, ok := snap.Lookup(derived.ID); ok {
+		return fmt.Errorf("%w: %s", ErrDuplicateMetric, derived.ID)
+	}
+	for _, ref := range derived.Refs {
+		if _, ok := snap.Lookup(ref); !ok {
+			return fmt.Errorf("derived metric %s uses unknown series %s", derived.ID, ref)
+		}
+	}
+
+	eph.MetricsMU.Lock()
+	eph.Derived = append(eph.Derived, derived)
+	eph.MetricsMU.Unlock()
+
+	slog.Debug("GOT DERIVED",
+		slog.String("id", derived.ID),
+		slog.String("expr", dc.Expr))
+
+	eph.Publish()
+	return nil
+}
+
+// exprParser is a recursive descent parser for derived expressions
+type exprParser struct {
+	input string
+	pos   int
+	refs  []string
+}
+
+func (p *exprParser) parse() (exprNode, error) {
+	node, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.input) {
+		return nil, fmt.Errorf("unexpected %q at %d", p.input[p.pos], p.pos)
+	}
+	return node, nil
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.input) && unicode.IsSpace(rune(p.input[p.pos])) {
+		p.pos++
+	}
+}
+
+// peek returns the next character after spaces, or 0 at the end
+func (p *exprParser) peek() byte {
+	p.skipSpace()
+	if p.pos >= len(p.input) {
+		return 0
+	}
+	return p.input[p.pos]
+}
+
+func (p *exprParser) parseSum() (exprNode, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.peek(); op == '+' || op == '-'; op = p.peek() {
+		p.pos++
+		right, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseProduct() (exprNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.peek(); op == '*' || op == '/'; op = p.peek() {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if p.peek() == '-' {
+		p.pos++
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return negNode{expr: expr}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	c := p.peek()
+	switch {
+	case c == 0:
+		return nil, fmt.Errorf("unexpected end of expression")
+	case c == '(':
+		p.pos++
+		node, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ')' {
+			return nil, fmt.Errorf("missing ) at %d", p.pos)
+		}
+		p.pos++
+		return node, nil
+	case c >= '0' && c <= '9' || c == '.':
+		return p.parseNumber()
+	case isIdentChar(c):
+		return p.parseIdent()
+	}
+	return nil, fmt.Errorf("unexpected %q at %d", c, p.pos)
+}
+
+func (p *exprParser) parseNumber() (exprNode, error) {
+	start := p.pos
+	for p.pos < len(p.input) && (strings.IndexByte("0123456789.eE", p.input[p.pos]) >= 0 ||
+		(p.pos > start && strings.IndexByte("+-", p.input[p.pos]) >= 0 && strings.IndexByte("eE", p.input[p.pos-1]) >= 0)) {
+		p.pos++
+	}
+	f, err := strconv.ParseFloat(p.input[start:p.pos], 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid number %q", p.input[start:p.pos])
+	}
+	return numberNode(f), nil
+}
+
+// parseIdent reads a function call or a series reference
+func (p *exprParser) parseIdent() (exprNode, error) {
+	name := p.readIdent()
+
+	if p.pos < len(p.input) && p.input[p.pos] == '(' {
+		return p.parseWindow(name)
+	}
+
+	ref, err := p.finishRef(name)
+	if err != nil {
+		return nil, err
+	}
+	return refNode(ref), nil
+}
+
+func (p *exprParser) readIdent() string {
+	start := p.pos
+	for p.pos < len(p.input) && isIdentChar(p.input[p.pos]) {
+		p.pos++
+	}
+	return p.input[start:p.pos]
+}
+
+// finishRef completes a series reference that began with name.
+// A type followed directly by / and an algorithm is a built-in series,
+// so int/up is a series while int / up divides two series.
+func (p *exprParser) finishRef(name string) (string, error) {
+	if slices.Contains(NTypes, name) && p.pos+1 < len(p.input) &&
+		p.input[p.pos] == '/' && isIdentChar(p.input[p.pos+1]) {
+		p.pos++
+		algo := p.readIdent()
+		ref := builtinName(name, algo)
+		p.refs = append(p.refs, ref)
+		return ref, nil
+	}
+
+	selector := name
+	if p.pos < len(p.input) && p.input[p.pos] == '{' {
+		end := strings.IndexByte(p.input[p.pos:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("missing } at %d", p.pos)
+		}
+		selector += p.input[p.pos : p.pos+end+1]
+		p.pos += end + 1
+	}
+
+	metric, labels, err := parseSelector(selector)
+	if err != nil {
+		return "", err
+	}
+	ref := SeriesID(metric, labels)
+	p.refs = append(p.refs, ref)
+	return ref, nil
+}
+
+func (p *exprParser) parseWindow(fn string) (exprNode, error) {
+	if fn != "avg" && fn != "min" && fn != "max" {
+		return nil, fmt.Errorf("unknown function %s", fn)
+	}
+	p.pos++ // (
+
+	p.skipSpace()
+	ref, err := p.finishRef(p.readIdent())
+	if err != nil {
+		return nil, err
+	}
+	if p.peek() != ',' {
+		return nil, fmt.Errorf("%s needs a series and a number of ticks", fn)
+	}
+	p.pos++
+
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.input) && p.input[p.pos] >= '0' && p.input[p.pos] <= '9' {
+		p.pos++
+	}
+	ticks, err := strconv.Atoi(p.input[start:p.pos])
+	if err != nil || ticks <= 0 {
+		return nil, fmt.Errorf("%s needs a positive number of ticks", fn)
+	}
+	if p.peek() != ')' {
+		return nil, fmt.Errorf("missing ) at %d", p.pos)
+	}
+	p.pos++
+
+	return windowNode{fn: fn, ref: ref, ticks: ticks}, nil
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c == ':' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
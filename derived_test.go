@@ -0,0 +1,177 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// Snapshot holding the given series values
+func testSnapshot(values map[string]string) *Snapshot {
+	snap := &Snapshot{index: make(map[string]int)}
+	for id, v := range values {
+		snap.index[id] = len(snap.Samples)
+		snap.Samples = append(snap.Samples, Sample{ID: id, Value: v})
+	}
+	return snap
+}
+
+func TestDerived_Eval(t *testing.T) {
+	snap := testSnapshot(map[string]string{
+		"Metric_int_up":             "6",
+		"Metric_int_down":           "2",
+		"Metric_float_up":           "1.5",
+		`http_requests{code="500"}`: "3",
+		"broken":                    "NaN",
+	})
+
+	tests := []struct {
+		name string
+		expr string
+		want float64
+	}{
+		{name: "Number", expr: "42", want: 42},
+		{name: "Exponent number", expr: "1.5e2", want: 150},
+		{name: "Built-in series", expr: "int/up", want: 6},
+		{name: "Error ratio", expr: "int/up / (int/down + 1)", want: 2},
+		{name: "Precedence", expr: "int/up + int/down * 2", want: 10},
+		{name: "Division of two series", expr: "int/up/int/down", want: 3},
+		{name: "Negation", expr: "-float/up * 2", want: -3},
+		{name: "Labelled series", expr: `http_requests{code="500"} * 2`, want: 6},
+		{name: "Division by zero", expr: "int/up / 0", want: math.Inf(1)},
+		{name: "Window without history", expr: "avg(int/up, 5)", want: 6},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := NewDerived(DerivedConfig{Name: "test", Expr: tt.expr})
+			assertError(t, err, nil)
+			got := d.Root.eval(snap)
+			if got != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	t.Run("Float results keep a digit by default", func(t *testing.T) {
+		d, err := NewDerived(DerivedConfig{Name: "test", Expr: "92 / 5"})
+		assertError(t, err, nil)
+		assertInt(t, d.Config.Tail, defTail)
+		if got := d.Sample(snap).Value; got != "18.4" {
+			t.Errorf("Expected 18.4, got %s", got)
+		}
+
+		d, err = NewDerived(DerivedConfig{Name: "test", Expr: "92 / 5", Type: "int"})
+		assertError(t, err, nil)
+		if got := d.Sample(snap).Value; got != "18" {
+			t.Errorf("Expected 18 for an int result, got %s", got)
+		}
+	})
+
+	t.Run("Missing and special values are NaN", func(t *testing.T) {
+		for _, expr := range []string{"nope + 1", "broken * 2"} {
+			d, err := NewDerived(DerivedConfig{Name: "test", Expr: expr})
+			assertError(t, err, nil)
+			if got := d.Root.eval(snap); !math.IsNaN(got) {
+				t.Errorf("Expected NaN for %s, got %v", expr, got)
+			}
+		}
+	})
+}
+
+func TestNewDerived_Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config DerivedConfig
+	}{
+		{name: "Empty expression", config: DerivedConfig{Name: "test", Expr: ""}},
+		{name: "Unbalanced parentheses", config: DerivedConfig{Name: "test", Expr: "(int/up + 1"}},
+		{name: "Trailing operator", config: DerivedConfig{Name: "test", Expr: "int/up +"}},
+		{name: "Unknown function", config: DerivedConfig{Name: "test", Expr: "sum(int/up, 5)"}},
+		{name: "Window without ticks", config: DerivedConfig{Name: "test", Expr: "avg(int/up)"}},
+		{name: "Window with zero ticks", config: DerivedConfig{Name: "test", Expr: "avg(int/up, 0)"}},
+		{name: "Unclosed labels", config: DerivedConfig{Name: "test", Expr: `up{code="500"`}},
+		{name: "Invalid name", config: DerivedConfig{Name: "error-ratio", Expr: "1"}},
+		{name: "Invalid type", config: DerivedConfig{Name: "test", Expr: "1", Type: "hex"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewDerived(tt.config)
+			assertGotError(t, err)
+		})
+	}
+}
+
+func TestEPHandle_AddDerived(t *testing.T) {
+	eph := NewEPHandle([]string{"exp", "float", "int"}, []string{"up", "down"})
+	defer eph.Ticker.Stop()
+	mux := eph.SetupMux()
+
+	err := eph.AddDerived(DerivedConfig{Name: "error_ratio", Expr: "int/up / (int/down + 1)", Tail: 4})
+	assertError(t, err, nil)
+	err = eph.AddDerived(DerivedConfig{Name: "smooth_up", Expr: "avg(int/up, 3)", Tail: 2})
+	assertError(t, err, nil)
+	err = eph.AddDerived(DerivedConfig{Name: "doubled_ratio", Expr: "error_ratio * 2", Tail: 4})
+	assertError(t, err, nil)
+
+	t.Run("Rejects unknown series", func(t *testing.T) {
+		err := eph.AddDerived(DerivedConfig{Name: "bad", Expr: "int/sideways + 1"})
+		assertGotError(t, err)
+	})
+
+	t.Run("Rejects duplicates", func(t *testing.T) {
+		err := eph.AddDerived(DerivedConfig{Name: "error_ratio", Expr: "1"})
+		assertGotError(t, err)
+	})
+
+	for i := 0; i < 3; i++ {
+		eph.Advance()
+	}
+
+	t.Run("Evaluated each tick", func(t *testing.T) {
+		snap := eph.Snapshot()
+		up, _ := snap.Lookup("Metric_int_up")
+		down, _ := snap.Lookup("Metric_int_down")
+		ratio, ok := snap.Lookup("error_ratio")
+		if !ok {
+			t.Fatal("Expected error_ratio in the snapshot")
+		}
+
+		want := parseSampleValue(up.Value) / (parseSampleValue(down.Value) + 1)
+		if ratio.Value != strconv.FormatFloat(want, 'f', 4, 64) {
+			t.Errorf("Expected %v, got %s", want, ratio.Value)
+		}
+
+		doubled, _ := snap.Lookup("doubled_ratio")
+		if doubled.Value != strconv.FormatFloat(parseSampleValue(ratio.Value)*2, 'f', 4, 64) {
+			t.Errorf("Expected double of %s, got %s", ratio.Value, doubled.Value)
+		}
+	})
+
+	t.Run("Moving average over history", func(t *testing.T) {
+		snap := eph.Snapshot()
+		up, _ := snap.Lookup("Metric_int_up")
+		var sum float64
+		points := up.History.Last(3)
+		for _, p := range points {
+			sum += parseSampleValue(p.Value)
+		}
+		smooth, _ := snap.Lookup("smooth_up")
+		if smooth.Value != strconv.FormatFloat(sum/float64(len(points)), 'f', 2, 64) {
+			t.Errorf("Expected average of %v, got %s", points, smooth.Value)
+		}
+	})
+
+	t.Run("Served on metrics and series", func(t *testing.T) {
+		for _, target := range []string{"/metrics", "/series/error_ratio"} {
+			r := httptest.NewRequest("GET", target, nil)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
+			assertStatus(t, w.Code, http.StatusOK)
+			assertStringContains(t, w.Body.String(), "error_ratio: ")
+		}
+	})
+}
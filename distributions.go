@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+const (
+	z99             = 2.3263478740408408 // Standard normal 99th percentile
+	defObservations = 10                 // Histogram observations per tick
+)
+
+// Distribution draws heavy-tailed values, e.g. latency,
+// configured by the median and 99th percentile instead of shape parameters.
+type Distribution struct {
+	Kind  string  // lognormal, pareto or exponential
+	Mu    float64 // Log-normal mean of ln(x)
+	Sigma float64 // Log-normal deviation of ln(x)
+	Alpha float64 // Pareto tail index
+	Xm    float64 // Pareto minimum
+	Rate  float64 // Exponential rate
+	Rand  *Rand   // Source of draws
+}
+
+// NewDistribution solves the shape parameters from the median and p99.
+// The exponential only has one parameter, so its p99 is always 6.64 times the median.
+func NewDistribution(kind string, median, p99 float64, rng *Rand) Distribution {
+	d := Distribution{Kind: kind, Rand: rng}
+	switch kind {
+	case "lognormal":
+		d.Mu = math.Log(median)
+		d.Sigma = (math.Log(p99) - d.Mu) / z99
+	case "pareto":
+		// P(X > x) = (xm/x)^alpha, so p99/median = 50^(1/alpha)
+		d.Alpha = math.Log(50) / math.Log(p99/median)
+		d.Xm = median / math.Pow(2, 1/d.Alpha)
+	case "exponential":
+		d.Rate = math.Ln2 / median
+	}
+	return d
+}
+
+// Draw returns one value of the distribution
+func (d Distribution) Draw() float64 {
+	switch d.Kind {
+	case "lognormal":
+		return math.Exp(d.Mu + d.Sigma*d.Rand.NormFloat64())
+	case "pareto":
+		return d.Xm / math.Pow(1-d.Rand.Float64(), 1/d.Alpha)
+	default:
+		return d.Rand.ExpFloat64() / d.Rate
+	}
+}
+
+// NewDistributionCycBuffer creates a gauge of values drawn from the distribution
+func NewDistributionCycBuffer(maxSize int, d Distribution, f string, tail int) *CycBuffer {
+	values := make([]string, 0, maxSize)
+	for i := 0; i < maxSize; i++ {
+		values = append(values, FormatValue(d.Draw(), f, tail))
+	}
+
+	return &CycBuffer{
+		NType:   f,
+		MAlgo:   d.Kind,
+		Values:  values,
+		MaxSize: maxSize,
+		Index:   0,
+		Rand:    d.Rand,
+	}
+}
+
+// distribution reads the median and p99 from the series parameters.
+// The median defaults to Limit * Mod and the p99 to ten times the median.
+func (sc *SeriesConfig) distribution() Distribution {
+	median := sc.param("median", float64(sc.Limit)*sc.Mod)
+	return NewDistribution(sc.Algo, median, sc.param("p99", 10*median), sc.rand)
+}
+
+// validateDistribution checks percentiles and histogram buckets
+func (sc *SeriesConfig) validateDistribution() error {
+	median := sc.param("median", 1)
+	if median <= 0 {
+		return fmt.Errorf("median for metric %s must be positive", sc.Name)
+	}
+	if sc.Algo != "exponential" && sc.param("p99", 10*median) <= median {
+		return fmt.Errorf("p99 for metric %s must be above the median", sc.Name)
+	}
+	if sc.param("observations", defObservations) < 0 {
+		return fmt.Errorf("observations for metric %s cannot be negative", sc.Name)
+	}
+	for i := 1; i < len(sc.Buckets); i++ {
+		if sc.Buckets[i] <= sc.Buckets[i-1] {
+			return fmt.Errorf("buckets for metric %s must increase", sc.Name)
+		}
+	}
+	return nil
+}
+
+// Histogram counts observations of a distribution in cumulative buckets,
+// served as {name}_bucket with an le label, {name}_sum and {name}_count.
+type Histogram struct {
+	MU           sync.Mutex
+	Dist         Distribution
+	Buckets      []float64 // Upper bounds, +Inf is added
+	Observations int       // Values observed every tick
+	Counts       []uint64  // Observations in each bucket, not cumulative
+	Sum          float64
+	Count        uint64
+	IDs          []string   // Series IDs of buckets, sum and count
+	Histories    []*History // History of each series in IDs
+}
+
+// NewHistogram creates an empty histogram for a series
+func NewHistogram(sc SeriesConfig) *Histogram {
+	h := &Histogram{
+		Dist:         sc.distribution(),
+		Buckets:      append(slices.Clone(sc.Buckets), math.Inf(1)),
+		Observations: int(sc.param("observations", defObservations)),
+	}
+	h.Counts = make([]uint64, len(h.Buckets))
+
+	for _, s := range h.series(sc.Name, sc.Labels) {
+		h.IDs = append(h.IDs, SeriesID(s.name, s.labels))
+		h.Histories = append(h.Histories, NewHistory(sc.history))
+	}
+	return h
+}
+
+type histogramSeries struct {
+	name   string
+	labels map[string]string
+}
+
+// series lists the bucket, sum and count series in the order they are served
+func (h *Histogram) series(name string, labels map[string]string) []histogramSeries {
+	var series []histogramSeries
+	for _, le := range h.Buckets {
+		bucket := map[string]string{"le": FormatValue(le, "float", -1)}
+		for k, v := range labels {
+			bucket[k] = v
+		}
+		series = append(series, histogramSeries{name + "_bucket", bucket})
+	}
+	return append(series,
+		histogramSeries{name + "_sum", labels},
+		histogramSeries{name + "_count", labels})
+}
+
+// Observe draws this tick's observations into the buckets
+func (h *Histogram) Observe() {
+	h.MU.Lock()
+	defer h.MU.Unlock()
+
+	for i := 0; i < h.Observations; i++ {
+		v := h.Dist.Draw()
+		bucket, _ := slices.BinarySearch(h.Buckets, v)
+		h.Counts[bucket]++
+		h.Sum += v
+		h.Count++
+	}
+}
+
+// Samples returns the cumulative buckets followed by the sum and count
+func (h *Histogram) Samples(name string, labels map[string]string) []Sample {
+	h.MU.Lock()
+	values := make([]string, 0, len(h.IDs))
+	cumulative := uint64(0)
+	for _, c := range h.Counts {
+		cumulative += c
+		values = append(values, strconv.FormatUint(cumulative, 10))
+	}
+	values = append(values,
+		strconv.FormatFloat(h.Sum, 'f', -1, 64),
+		strconv.FormatUint(h.Count, 10))
+	h.MU.Unlock()
+
+	samples := make([]Sample, 0, len(h.IDs))
+	for i, s := range h.series(name, labels) {
+		nt := "int"
+		if s.name == name+"_sum" {
+			nt = "float"
+		}
+		samples = append(samples, Sample{
+			ID:      h.IDs[i],
+			Name:    s.name,
+			Labels:  s.labels,
+			NType:   nt,
+			MAlgo:   h.Dist.Kind,
+			Value:   values[i],
+			History: h.Histories[i],
+		})
+	}
+	return samples
+}
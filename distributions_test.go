@@ -0,0 +1,137 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+)
+
+func TestDistribution_Percentiles(t *testing.T) {
+	tests := []struct {
+		name   string
+		kind   string
+		median float64
+		p99    float64
+	}{
+		{name: "Log-normal", kind: "lognormal", median: 100, p99: 2000},
+		{name: "Pareto", kind: "pareto", median: 50, p99: 5000},
+		{name: "Exponential", kind: "exponential", median: 10, p99: 10 * math.Log(100) / math.Ln2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Seeded, a heavy tail moves the p99 of an unseeded run by several percent
+			d := NewDistribution(tt.kind, tt.median, tt.p99, NewRand(7))
+			values := make([]float64, 200000)
+			for i := range values {
+				values[i] = d.Draw()
+			}
+			sort.Float64s(values)
+
+			median := values[len(values)/2]
+			p99 := values[len(values)*99/100]
+			if math.Abs(median/tt.median-1) > 0.05 {
+				t.Errorf("Expected median near %v, got %v", tt.median, median)
+			}
+			if math.Abs(p99/tt.p99-1) > 0.1 {
+				t.Errorf("Expected p99 near %v, got %v", tt.p99, p99)
+			}
+		})
+	}
+}
+
+func TestHistogram_Observe(t *testing.T) {
+	h := NewHistogram(SeriesConfig{
+		Name:    "request_seconds",
+		Labels:  map[string]string{"job": "api"},
+		Algo:    "exponential",
+		Params:  map[string]float64{"median": 0.1, "observations": 1000},
+		Buckets: []float64{0.1, 1},
+	})
+	h.Observe()
+	h.Observe()
+
+	samples := h.Samples("request_seconds", map[string]string{"job": "api"})
+	assertInt(t, len(samples), 5)
+
+	values := map[string]float64{}
+	for _, s := range samples {
+		values[s.ID] = parseSampleValue(s.Value)
+	}
+
+	low := values[`request_seconds_bucket{job="api",le="0.1"}`]
+	high := values[`request_seconds_bucket{job="api",le="1"}`]
+	inf := values[`request_seconds_bucket{job="api",le="+Inf"}`]
+	count := values[`request_seconds_count{job="api"}`]
+	sum := values[`request_seconds_sum{job="api"}`]
+
+	if count != 2000 || inf != count {
+		t.Errorf("Expected 2000 observations in +Inf and count, got %v and %v", inf, count)
+	}
+	if !(low <= high && high <= inf) {
+		t.Errorf("Expected cumulative buckets, got %v, %v, %v", low, high, inf)
+	}
+	// Half of the observations are under the median
+	if math.Abs(low/count-0.5) > 0.05 {
+		t.Errorf("Expected about half under 0.1, got %v of %v", low, count)
+	}
+	// The mean of the exponential is median / ln 2
+	if math.Abs(sum/count-0.1/math.Ln2) > 0.02 {
+		t.Errorf("Expected a mean near %v, got %v", 0.1/math.Ln2, sum/count)
+	}
+}
+
+func TestSeriesConfig_Distribution(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  SeriesConfig
+		wantErr bool
+	}{
+		{name: "Log-normal gauge", config: SeriesConfig{Name: "latency", Type: "float", Algo: "lognormal", Tail: 3, Params: map[string]float64{"median": 0.2, "p99": 3}}},
+		{name: "Pareto histogram", config: SeriesConfig{Name: "latency", Type: "float", Algo: "pareto", Buckets: []float64{0.5, 1, 5}}},
+		{name: "Exponential defaults", config: SeriesConfig{Name: "latency", Type: "float", Algo: "exponential"}},
+		{name: "p99 under median", config: SeriesConfig{Name: "latency", Type: "float", Algo: "lognormal", Params: map[string]float64{"median": 2, "p99": 1}}, wantErr: true},
+		{name: "Negative median", config: SeriesConfig{Name: "latency", Type: "float", Algo: "pareto", Params: map[string]float64{"median": -1}}, wantErr: true},
+		{name: "p99 on exponential", config: SeriesConfig{Name: "latency", Type: "float", Algo: "exponential", Params: map[string]float64{"p99": 9}}, wantErr: true},
+		{name: "Unsorted buckets", config: SeriesConfig{Name: "latency", Type: "float", Algo: "lognormal", Buckets: []float64{1, 0.5}}, wantErr: true},
+		{name: "Buckets without distribution", config: SeriesConfig{Name: "latency", Type: "float", Algo: "up", Buckets: []float64{1}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewMetric(tt.config)
+			if tt.wantErr {
+				assertGotError(t, err)
+				return
+			}
+			assertError(t, err, nil)
+		})
+	}
+}
+
+func TestEPHandle_HistogramMetric(t *testing.T) {
+	eph := NewEPHandle([]string{"exp", "float", "int"}, []string{"up", "down"})
+	defer eph.Ticker.Stop()
+	mux := eph.SetupMux()
+
+	err := eph.AddMetric(SeriesConfig{
+		Name:    "request_seconds",
+		Type:    "float",
+		Algo:    "lognormal",
+		Params:  map[string]float64{"median": 0.2, "p99": 2, "observations": 5},
+		Buckets: []float64{0.25, 1},
+	})
+	assertError(t, err, nil)
+	eph.Advance()
+
+	r := httptest.NewRequest("GET", "/metrics", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	assertStatus(t, w.Code, http.StatusOK)
+
+	assertStringContains(t, w.Body.String(), `request_seconds_bucket{le="+Inf"}: 5`)
+	assertStringContains(t, w.Body.String(), `request_seconds_count: 5`)
+	assertStringContains(t, w.Body.String(), `request_seconds_sum: `)
+}
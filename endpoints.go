@@ -1,13 +1,17 @@
 package main
 
 import (
+	"crypto/tls"
 	"fmt"
 	"log/slog"
+	"maps"
+	"math"
 	"net/http"
-	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -18,27 +22,63 @@ const (
 	defLimit = 10
 	defTail  = 1
 	defMod   = 1
+	defSpeed = 1
 )
 
 // EPHandle is called by main() and contains the mux
 // It handles and routes all Endpoints (type EP)
 type EPHandle struct {
-	MTypes map[string]*MType
-	Server *http.Server
-	Mux    *mux.Router
-	Ticker *time.Ticker
+	MTypes      map[string]*MType
+	Metrics     map[string]*Metric // User-defined metrics by series ID
+	Templates   []*Template        // High-cardinality generators
+	Derived     []*Derived         // Metrics calculated from other series, in order
+	States      []*StateMachine    // Metrics moving between named states
+	MetricsMU   sync.RWMutex
+	Snap        atomic.Pointer[Snapshot] // Values served by handlers
+	PubMU       sync.Mutex               // Serializes publishing snapshots
+	Audit       *AuditLog                // Values served to each client
+	Env         *Env                     // Env Var overrides, read before the process environment
+	Rand        *Rand                    // Source for every generator, nil uses the global source
+	HistorySize int                      // Values kept in the history of each series, HISTORY_SIZE
+	Mounts      map[string]*EPHandle     // Targets served under a path prefix
+	LastTick    atomic.Int64             // Unix nanoseconds of the last Advance, or the start
+	Ready       atomic.Bool              // Serving with every buffer and metric loaded
+	Paused      atomic.Bool              // Ticks do not advance buffers, steps still do
+	Internal    *Instruments             // Measurements of toadlester itself
+	Auth        ControlAuth              // Credentials for endpoints that change state
+	TLS         *tls.Config              // Serves HTTPS when set
+	Server      *http.Server
+	Mux         *mux.Router
+	Ticker      *time.Ticker
 }
 
 type MType struct {
 	MU             sync.Mutex
-	Name           string                // Metric name
-	RandomBuffer   []string              // Randomized metrics
-	ShiftRegisters map[string]*CycBuffer // Map of Cyclical Buffers
+	Name           string                  // Metric name
+	RandomBuffer   []string                // Randomized metrics
+	ShiftRegisters map[string]*CycBuffer   // Map of Cyclical Buffers, guarded by the handle's MetricsMU
+	Overrides      map[string]SeriesConfig // Settings changed by the series API, by algorithm, until the next reset
 }
 
 // NewEPHandle initializes MetricTypes, Buffers, and the Ticker.
 // Server and Mux are done by calling func.
 func NewEPHandle(mtypes, balgos []string) *EPHandle {
+	return NewTargetEPHandle(mtypes, balgos, ProcessEnv(), nil)
+}
+
+// NewTargetEPHandle initializes a handle with its own Env Var overrides and random source,
+// so several simulated targets can run in one process.
+func NewTargetEPHandle(mtypes, balgos []string, env *Env, rng *Rand) *EPHandle {
+	if env == nil {
+		env = NewEnv(nil)
+	}
+	eph := &EPHandle{
+		Metrics:  make(map[string]*Metric),
+		Env:      env,
+		Rand:     rng,
+		Internal: NewInstruments(),
+	}
+	eph.HistorySize = eph.Env.Int("HISTORY_SIZE", defHistory)
 	names := make(map[string]*MType)
 
 	// Init each type with its name
@@ -47,6 +87,7 @@ func NewEPHandle(mtypes, balgos []string) *EPHandle {
 			Name:           mt,
 			RandomBuffer:   make([]string, len(balgos)),
 			ShiftRegisters: make(map[string]*CycBuffer),
+			Overrides:      make(map[string]SeriesConfig),
 		}
 	}
 
@@ -54,7 +95,7 @@ func NewEPHandle(mtypes, balgos []string) *EPHandle {
 	for _, algo := range balgos { // algorithms belong to buffers
 		for _, mt := range mtypes { // numeric types belong to mtypes
 			// Series of monotonic values
-			newBuff := getConfiguredBuffer(mt, algo)
+			newBuff := eph.getConfiguredBuffer(mt, algo)
 			names[mt].ShiftRegisters[algo] = newBuff
 
 			slog.Debug("GOT SHIFT REGISTER",
@@ -62,7 +103,7 @@ func NewEPHandle(mtypes, balgos []string) *EPHandle {
 				slog.Any("buffer", names[mt].ShiftRegisters[algo]))
 
 			// Static Random values
-			newRandomizer := getRandomizedBuffer(mt, "random")
+			newRandomizer := eph.getRandomizedBuffer(mt, "random")
 			names[mt].RandomBuffer = newRandomizer.Values
 
 			slog.Debug("GOT RANDOMIZER",
@@ -71,21 +112,65 @@ func NewEPHandle(mtypes, balgos []string) *EPHandle {
 		}
 	}
 
-	return &EPHandle{
-		MTypes: names,
-		Ticker: time.NewTicker(1 * time.Second),
+	// Captured metrics are replayed for types with a file configured
+	for _, mt := range mtypes {
+		if eph.Env.Get(strings.ToUpper(mt)+"_REPLAY") == "ENOENT" {
+			continue
+		}
+		if replay := eph.getConfiguredBuffer(mt, "replay"); replay != nil {
+			names[mt].ShiftRegisters["replay"] = replay
+		}
 	}
+
+	eph.MTypes = names
+	eph.Audit = NewAuditLog(eph.Env.Int("AUDIT_SIZE", defAudit), eph.Env.Int("AUDIT_VALUES", defAuditValues))
+	eph.Ticker = time.NewTicker(TickInterval)
+	eph.LastTick.Store(time.Now().UnixNano())
+	eph.Publish()
+
+	return eph
 }
 
 // SetupMux provides a new Mux with its internal routing configured
 // These are the control points for Toadlester
 func (eph *EPHandle) SetupMux() *mux.Router {
 	r := mux.NewRouter()
+	r.Use(eph.instrument)
+
+	// Middleware only runs on matched routes, so the router's own answers are measured here
+	r.NotFoundHandler = eph.instrument(http.NotFoundHandler())
+	r.MethodNotAllowedHandler = eph.instrument(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}))
+
+	for prefix, target := range eph.Mounts {
+		r.PathPrefix(prefix + "/").Handler(http.StripPrefix(prefix, target.SetupMux()))
+	}
 
+	r.HandleFunc("/", eph.IndexHandler)
+	r.HandleFunc("/openapi.json", eph.OpenAPIHandler)
 	r.HandleFunc("/rand/all", eph.RandDataAllHandler)
 	r.HandleFunc("/metrics", eph.SeriesDataAllHandler)
-	r.PathPrefix("/reset").HandlerFunc(eph.ResetHandler)
+	r.PathPrefix("/reset").Methods(http.MethodPost, http.MethodPut).HandlerFunc(eph.requireControl(eph.ResetHandler))
 	r.PathPrefix("/series").HandlerFunc(eph.SeriesInternalDataHandler)
+	r.HandleFunc("/api/v1/query_range", eph.QueryRangeHandler)
+	r.HandleFunc("/api/v1/series", eph.SeriesListHandler).Methods(http.MethodGet)
+	r.HandleFunc("/api/v1/series", eph.requireControl(eph.SeriesCreateHandler)).Methods(http.MethodPost)
+	r.HandleFunc("/api/v1/series/{id:.+}", eph.SeriesGetHandler).Methods(http.MethodGet)
+	r.HandleFunc("/api/v1/series/{id:.+}", eph.requireControl(eph.SeriesPatchHandler)).Methods(http.MethodPatch)
+	r.HandleFunc("/api/v1/series/{id:.+}", eph.requireControl(eph.SeriesDeleteHandler)).Methods(http.MethodDelete)
+	r.HandleFunc("/audit", eph.AuditHandler)
+	r.HandleFunc("/healthz", eph.HealthHandler)
+	r.HandleFunc("/readyz", eph.ReadyHandler)
+	r.HandleFunc("/version", eph.VersionHandler)
+	r.HandleFunc("/internal/metrics", eph.InternalMetricsHandler)
+	r.HandleFunc("/control", eph.ControlHandler).Methods(http.MethodGet)
+	r.HandleFunc("/control/pause", eph.requireControl(eph.PauseHandler)).Methods(http.MethodPost)
+	r.HandleFunc("/control/resume", eph.requireControl(eph.ResumeHandler)).Methods(http.MethodPost)
+	r.HandleFunc("/control/step", eph.requireControl(eph.StepHandler)).Methods(http.MethodPost)
+	r.HandleFunc("/control/inject", eph.requireControl(eph.InjectHandler)).Methods(http.MethodPost)
+	r.HandleFunc("/ui", uiRedirect)
+	r.PathPrefix("/ui/").Handler(http.StripPrefix("/ui/", UIHandler()))
 
 	return r
 }
@@ -94,8 +179,6 @@ func (eph *EPHandle) SetupMux() *mux.Router {
 // It uses the final parameter of the API URI to set a new Env Var for that value.
 // Then a new buffer is requested, which reads Env Vars to configure.
 func (eph *EPHandle) ResetHandler(w http.ResponseWriter, r *http.Request) {
-	var output string
-
 	parts := strings.Split(r.URL.Path, "/")
 	if len(parts) != 4 {
 		slog.Error("Invalid reset data path")
@@ -112,27 +195,58 @@ func (eph *EPHandle) ResetHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Set the env var being changed
-	// TODO: Value validation
-	os.Setenv(strings.ToUpper(envvar), value)
-
-	// Locate buffer with params and update with new Env Var set
+	// Check the value first, a generator given a size or limit it cannot use panics
 	params := strings.Split(envvar, "_")
+	if err := validResetValue(params[1], value); err != nil {
+		slog.Error("Invalid reset value", slog.Any("error", err))
+		http.Error(w, "Invalid reset value: "+err.Error(), http.StatusBadRequest)
+		return
+	}
 
+	// Set the env var being changed, only for this handle when it has overrides
+	eph.Env.Set(strings.ToUpper(envvar), value)
+
+	// Locate buffer with params and update with new Env Var set
 	mtype := strings.ToLower(params[0])
 	mconf := strings.ToLower(params[1])
 	slog.Debug("params", slog.String("mtype", mtype), slog.String("malgo", mconf))
 
-	// Get new buffers for all algorithms of this mtype
-	for _, buff := range eph.MTypes[mtype].ShiftRegisters {
-		// Hold on to history for logging
-		oldValues := buff.Values
+	// Get new buffers for all algorithms of this mtype, built before locking
+	// so ticks are never held up by the generators
+	eph.MetricsMU.RLock()
+	algos := slices.Sorted(maps.Keys(eph.MTypes[mtype].ShiftRegisters))
+	eph.MetricsMU.RUnlock()
+	built := make(map[string]*CycBuffer, len(algos))
+	for _, algo := range algos {
+		built[algo] = eph.getConfiguredBuffer(mtype, algo)
+	}
+	output := eph.resetBuffers(r, mtype, envvar, value, built)
+
+	// Serve the new values without waiting for the next tick
+	eph.Publish()
+	eph.Internal.Resets.Add(1)
+
+	w.Header().Set("Content-Type", "application/plaintext")
+	w.Write([]byte(output))
+}
+
+// resetBuffers swaps the new values into the buffers of a type,
+// dropping settings from the series API, and reports what changed
+func (eph *EPHandle) resetBuffers(r *http.Request, mtype, envvar, value string, built map[string]*CycBuffer) string {
+	var output string
+
+	eph.MetricsMU.Lock()
+	defer eph.MetricsMU.Unlock()
 
-		// Get a new buffer
-		buff.MU.Lock()
-		newBuff := getConfiguredBuffer(buff.NType, buff.MAlgo)
-		buff.Values = newBuff.Values
-		buff.MU.Unlock()
+	clear(eph.MTypes[mtype].Overrides)
+	for algo, buff := range eph.MTypes[mtype].ShiftRegisters {
+		// Swap in the new values, holding on to history for logging
+		newBuff := built[algo]
+		if newBuff == nil {
+			output = output + fmt.Sprintf("Kept old %s values for %s\n", buff.MAlgo, envvar)
+			continue
+		}
+		oldValues := buff.Replace(newBuff)
 
 		output = output + fmt.Sprintf("Set new %s value %s for %s\n", buff.MAlgo, envvar, value)
 
@@ -142,11 +256,25 @@ func (eph *EPHandle) ResetHandler(w http.ResponseWriter, r *http.Request) {
 			slog.String("remote_addr", r.RemoteAddr),
 			slog.String("buffer", buff.MAlgo),
 			slog.String("old_values", strings.Join(oldValues, ", ")),
-			slog.String("new_values", strings.Join(buff.Values, ", ")))
+			slog.String("new_values", strings.Join(newBuff.Values, ", ")))
 	}
 
-	w.Header().Set("Content-Type", "application/plaintext")
-	w.Write([]byte(output))
+	return output
+}
+
+// validResetValue rejects sizes and limits a buffer cannot be built from
+func validResetValue(param, value string) error {
+	if param != "SIZE" && param != "LIMIT" {
+		return nil
+	}
+	n, err := strconv.Atoi(value)
+	switch {
+	case err != nil || n < 1:
+		return fmt.Errorf("%s must be a positive integer, got %s", param, value)
+	case param == "LIMIT" && n > math.MaxInt32:
+		return fmt.Errorf("LIMIT cannot be over %d, got %s", math.MaxInt32, value)
+	}
+	return nil
 }
 
 // Validates Env Var name against types and algorithms
@@ -165,6 +293,12 @@ func (eph *EPHandle) findEnvVar(find string) bool {
 			"LIMIT",
 			"TAIL",
 			"MOD",
+			"NAN",
+			"INF",
+			"NZERO",
+			"NEG",
+			"SPEED",
+			"ONCE",
 		}
 		for _, p := range algoparams {
 			if p == parts[1] {
@@ -178,12 +312,28 @@ func (eph *EPHandle) findEnvVar(find string) bool {
 	return front && back
 }
 
-// Series of monotonic values
-func getConfiguredBuffer(mt, algo string) *CycBuffer {
-	size := FillEnvVarInt(strings.ToUpper(mt)+"_SIZE", defSize)
-	limit := FillEnvVarInt(strings.ToUpper(mt)+"_LIMIT", defLimit)
-	tail := FillEnvVarInt(strings.ToUpper(mt)+"_TAIL", defTail)
-	modenv := FillEnvVar(strings.ToUpper(mt) + "_MOD")
+// Series of monotonic values, or a replayed capture.
+// Returns nil if the capture cannot be replayed.
+func (eph *EPHandle) getConfiguredBuffer(mt, algo string) *CycBuffer {
+	var buffer *CycBuffer
+	if algo == "replay" {
+		var err error
+		buffer, err = eph.getReplayBuffer(mt)
+		if err != nil {
+			slog.Error("Could not replay capture", slog.String("name", mt), slog.Any("error", err))
+			return nil
+		}
+	} else {
+		buffer = eph.newBuiltinBuffer(eph.builtinConfig(mt, algo))
+	}
+
+	buffer.History = NewHistory(eph.HistorySize)
+	return buffer
+}
+
+// builtinConfig reads the settings of a built-in series from its type's Env Vars
+func (eph *EPHandle) builtinConfig(mt, algo string) SeriesConfig {
+	modenv := eph.Env.Get(strings.ToUpper(mt) + "_MOD")
 	mod, err := strconv.ParseFloat(modenv, 64)
 	if err != nil {
 		slog.Debug("Default chosen",
@@ -191,23 +341,47 @@ func getConfiguredBuffer(mt, algo string) *CycBuffer {
 		mod = defMod
 	}
 
-	slog.Debug("INIT SHIFT REGISTER",
-		slog.String("name", mt),
-		slog.Int("size", size),
-		slog.Int("limit", limit),
-		slog.Int("tail", tail),
-		slog.Any("mod", mod),
-		slog.Any("algo", algo))
+	return SeriesConfig{
+		Name:  builtinName(mt, algo),
+		Type:  mt,
+		Algo:  algo,
+		Size:  eph.Env.Int(strings.ToUpper(mt)+"_SIZE", defSize),
+		Limit: eph.Env.Int(strings.ToUpper(mt)+"_LIMIT", defLimit),
+		Tail:  eph.Env.Int(strings.ToUpper(mt)+"_TAIL", defTail),
+		Mod:   mod,
+		// Special float values, in percent
+		Specials: Specials{
+			NaN:   eph.Env.Int(strings.ToUpper(mt)+"_NAN", 0),
+			Inf:   eph.Env.Int(strings.ToUpper(mt)+"_INF", 0),
+			NZero: eph.Env.Int(strings.ToUpper(mt)+"_NZERO", 0),
+			Neg:   eph.Env.Int(strings.ToUpper(mt)+"_NEG", 0),
+		},
+	}
+}
 
-	return NewShiftCycBuffer(size, limit, tail, mod, mt, algo)
+// newBuiltinBuffer builds the shift register of a built-in series
+func (eph *EPHandle) newBuiltinBuffer(sc SeriesConfig) *CycBuffer {
+	slog.Debug("INIT SHIFT REGISTER",
+		slog.String("name", sc.Type),
+		slog.Int("size", sc.Size),
+		slog.Int("limit", sc.Limit),
+		slog.Int("tail", sc.Tail),
+		slog.Any("mod", sc.Mod),
+		slog.Any("algo", sc.Algo),
+		slog.Any("specials", sc.Specials))
+
+	buffer := NewShiftCycBuffer(sc.Size, sc.Limit, sc.Tail, sc.Mod, sc.Type, sc.Algo, eph.Rand)
+	buffer.ApplySpecials(sc.Specials, sc.Tail)
+
+	return buffer
 }
 
 // Static Random values with different defaults
-func getRandomizedBuffer(mt, algo string) *CycBuffer {
-	size := FillEnvVarInt("RAND_SIZE", 1)
-	limit := FillEnvVarInt("RAND_LIMIT", 10000)
-	tail := FillEnvVarInt("RAND_TAIL", 4)
-	modenv := FillEnvVar("RAND_MOD")
+func (eph *EPHandle) getRandomizedBuffer(mt, algo string) *CycBuffer {
+	size := eph.Env.Int("RAND_SIZE", 1)
+	limit := eph.Env.Int("RAND_LIMIT", 10000)
+	tail := eph.Env.Int("RAND_TAIL", 4)
+	modenv := eph.Env.Get("RAND_MOD")
 	mod, err := strconv.ParseFloat(modenv, 64)
 	if err != nil {
 		slog.Debug("Default chosen",
@@ -223,7 +397,7 @@ func getRandomizedBuffer(mt, algo string) *CycBuffer {
 		slog.Any("mod", mod),
 		slog.Any("algo", algo))
 
-	return NewShiftCycBuffer(size, limit, tail, mod, mt, algo)
+	return NewShiftCycBuffer(size, limit, tail, mod, mt, algo, eph.Rand)
 }
 
 func (eph *EPHandle) findTypeKey(find string) bool {
@@ -236,6 +410,9 @@ func (eph *EPHandle) findTypeKey(find string) bool {
 }
 
 func (eph *EPHandle) findAlgoKey(find string) bool {
+	eph.MetricsMU.RLock()
+	defer eph.MetricsMU.RUnlock()
+
 	for _, t := range eph.MTypes {
 		for _, b := range t.ShiftRegisters {
 			if b.MAlgo == find {
@@ -247,8 +424,19 @@ func (eph *EPHandle) findAlgoKey(find string) bool {
 }
 
 // SeriesInternalDataHandler returns a metric from the series and algorithm requested
+// A single path element after /series is the name of a user-defined metric instead,
+// its label sets can be filtered with query parameters, e.g.: /series/http_requests?code=200
+// Recent values of a series are at /series/{type}/{algo}/history
 func (eph *EPHandle) SeriesInternalDataHandler(w http.ResponseWriter, r *http.Request) {
 	parts := strings.Split(r.URL.Path, "/")
+	if len(parts) == 3 {
+		eph.seriesMetricHandler(w, r, parts[2])
+		return
+	}
+	if len(parts) == 5 && parts[4] == "history" {
+		eph.HistoryHandler(w, r, builtinName(parts[2], parts[3]))
+		return
+	}
 	if len(parts) != 4 {
 		slog.Error("Invalid series data path")
 		http.Error(w, "Invalid series data path", http.StatusBadRequest)
@@ -270,40 +458,89 @@ func (eph *EPHandle) SeriesInternalDataHandler(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	// assign buffer as shift register
-	shiftReg := eph.MTypes[algotype].ShiftRegisters[algo]
-	shiftReg.MU.Lock()
-	algoVal := shiftReg.Values[shiftReg.Index]
-	shiftReg.MU.Unlock()
+	// The type and algorithm exist, but not necessarily together
+	snap := eph.Snapshot()
+	sample, ok := snap.Lookup(builtinName(algotype, algo))
+	if !ok {
+		slog.Error("Invalid series data path: " + algotype + "/" + algo)
+		http.Error(w, "Invalid series data path: "+algotype+"/"+algo, http.StatusBadRequest)
+		return
+	}
+	if sample.Omit {
+		slog.Error("Series omitted: " + sample.ID)
+		http.Error(w, "Series omitted: "+sample.ID, http.StatusNotFound)
+		return
+	}
+	algoVal := sample.Value
 
 	slog.Info("Algorithm match",
 		slog.String("method", r.Method),
 		slog.String("request", r.RequestURI),
 		slog.String("remote_addr", r.RemoteAddr),
 		slog.String("requested.type", algotype),
-		slog.String("algo.name", shiftReg.MAlgo),
+		slog.String("algo.name", sample.MAlgo),
 		slog.String("algo.value", algoVal),
-		slog.Any("full.values", shiftReg.Values),
+		slog.Any("full.values", sample.Values),
 	)
 
 	w.Header().Set("Content-Type", "application/plaintext; charset=utf-8")
+	if sample.Empty {
+		slog.Warn("Serving empty body", slog.String("request", r.RequestURI))
+		return
+	}
+
+	eph.Audit.Record(r, snap.Tick, []Sample{sample})
 	output := fmt.Sprintf("Metric_%s_%s: %s\n", algotype, algo, algoVal)
 	w.Write([]byte(output))
 }
 
+// seriesMetricHandler returns every label set of a user-defined metric
+func (eph *EPHandle) seriesMetricHandler(w http.ResponseWriter, r *http.Request, name string) {
+	matchers := make(map[string]string)
+	for k, v := range r.URL.Query() {
+		matchers[k] = v[0]
+	}
+
+	var output string
+	snap := eph.Snapshot()
+	samples, empty := served(snap.Match(name, matchers))
+	for _, sample := range samples {
+		output = output + fmt.Sprintf("%s: %s\n", sample.ID, sample.Value)
+	}
+
+	if output == "" {
+		slog.Error("Invalid series metric: " + name)
+		http.Error(w, "Invalid series metric: "+name, http.StatusNotFound)
+		return
+	}
+
+	slog.Info("Metric match",
+		slog.String("method", r.Method),
+		slog.String("request", r.RequestURI),
+		slog.String("remote_addr", r.RemoteAddr),
+		slog.String("metric.name", name),
+		slog.Any("metric.labels", matchers))
+
+	w.Header().Set("Content-Type", "application/plaintext; charset=utf-8")
+	if empty {
+		slog.Warn("Serving empty body", slog.String("metric.name", name))
+		return
+	}
+
+	eph.Audit.Record(r, snap.Tick, samples)
+	w.Write([]byte(output))
+}
+
+// SeriesDataAllHandler returns every series, followed by user-defined metrics
 func (eph *EPHandle) SeriesDataAllHandler(w http.ResponseWriter, r *http.Request) {
 	report := map[string]string{}
-	var output string
+	var output strings.Builder
 
-	for _, mt := range eph.MTypes {
-		mt.MU.Lock()
-		for _, buff := range mt.ShiftRegisters {
-			idx := buff.NType + buff.MAlgo
-			report[idx] = buff.Values[buff.Index]
-			output = output + fmt.Sprintf("Metric_%s_%s: %s\n",
-				buff.NType, buff.MAlgo, report[idx])
-		}
-		mt.MU.Unlock()
+	snap := eph.Snapshot()
+	samples, empty := served(snap.Samples)
+	for _, sample := range samples {
+		report[sample.NType+sample.MAlgo] = sample.Value
+		fmt.Fprintf(&output, "%s: %s\n", sample.ID, sample.Value)
 	}
 
 	slog.Info("Randomizer match",
@@ -318,20 +555,21 @@ func (eph *EPHandle) SeriesDataAllHandler(w http.ResponseWriter, r *http.Request
 		slog.String("intdown", report["intdown"]))
 
 	w.Header().Set("Content-Type", "application/plaintext; charset=utf-8")
-	w.Write([]byte(output))
+	if empty {
+		slog.Warn("Serving empty body", slog.String("request", r.RequestURI))
+		return
+	}
+
+	eph.Audit.Record(r, snap.Tick, samples)
+	w.Write([]byte(output.String()))
 }
 
 // RandDataAllHandler returns randomly changing values in all supported types
 func (eph *EPHandle) RandDataAllHandler(w http.ResponseWriter, r *http.Request) {
-	for _, mt := range eph.MTypes {
-		mt.MU.Lock()
-	}
-	randexp := eph.MTypes["exp"].RandomBuffer[0]
-	randfloat := eph.MTypes["float"].RandomBuffer[0]
-	randint := eph.MTypes["int"].RandomBuffer[0]
-	for _, mt := range eph.MTypes {
-		mt.MU.Unlock()
-	}
+	snap := eph.Snapshot()
+	randexp := snap.Random["exp"]
+	randfloat := snap.Random["float"]
+	randint := snap.Random["int"]
 
 	slog.Info("Randomizer match",
 		slog.String("method", r.Method),
@@ -342,6 +580,12 @@ func (eph *EPHandle) RandDataAllHandler(w http.ResponseWriter, r *http.Request)
 		slog.String("random.integer", randint),
 	)
 
+	eph.Audit.Record(r, snap.Tick, []Sample{
+		{ID: "ExpMetric", NType: "exp", MAlgo: "random", Value: randexp},
+		{ID: "FloatMetric", NType: "float", MAlgo: "random", Value: randfloat},
+		{ID: "IntMetric", NType: "int", MAlgo: "random", Value: randint},
+	})
+
 	w.Header().Set("Content-Type", "application/plaintext; charset=utf-8")
 	output := fmt.Sprintf("ExpMetric: %s\nFloatMetric: %s\nIntMetric: %s\n", randexp, randfloat, randint)
 	w.Write([]byte(output))
@@ -2,6 +2,7 @@ package main
 
 import (
 	"errors"
+	"math"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -155,6 +156,33 @@ func TestEPHandle_ResetHandler(t *testing.T) {
 			oldval:   strconv.Itoa(defSize),
 			newval:   "10",
 		},
+		{
+			name:     "Zero size",
+			target:   "/reset/INT_SIZE/0",
+			wantCode: http.StatusBadRequest,
+			mtype:    "int",
+			envvar:   "INT_SIZE",
+			oldval:   strconv.Itoa(defSize),
+			newval:   strconv.Itoa(defSize),
+		},
+		{
+			name:     "Zero limit",
+			target:   "/reset/INT_LIMIT/0",
+			wantCode: http.StatusBadRequest,
+			mtype:    "int",
+			envvar:   "INT_LIMIT",
+			oldval:   strconv.Itoa(defLimit),
+			newval:   strconv.Itoa(defLimit),
+		},
+		{
+			name:     "Limit over int32",
+			target:   "/reset/INT_LIMIT/2147483648",
+			wantCode: http.StatusBadRequest,
+			mtype:    "int",
+			envvar:   "INT_LIMIT",
+			oldval:   strconv.Itoa(defLimit),
+			newval:   strconv.Itoa(defLimit),
+		},
 		{
 			name:     "Reset Int Size",
 			target:   "/reset/INT_SIZE/11",
@@ -209,19 +237,23 @@ func TestEPHandle_ResetHandler(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			r := httptest.NewRequest("GET", tt.target, nil)
+			r := httptest.NewRequest("POST", tt.target, nil)
 			w := httptest.NewRecorder()
 
 			// Set the Env Var to the old value first to ensure it is changed
-			os.Setenv(tt.envvar, tt.oldval)
+			eph.Env.Set(tt.envvar, tt.oldval)
+			process := os.Getenv(tt.envvar)
 
 			// Serving and fetching this endpoint will automatically reset the Env Var
 			mux.ServeHTTP(w, r)
 
-			// Now check if it was changed
+			// Now check if it was changed, only for this handle
 			assertStatus(t, w.Code, tt.wantCode)
-			if os.Getenv(tt.envvar) != tt.newval {
-				t.Errorf("Expected variable to change to %s, got %s", tt.newval, os.Getenv(tt.envvar))
+			if eph.Env.Get(tt.envvar) != tt.newval {
+				t.Errorf("Expected variable to change to %s, got %s", tt.newval, eph.Env.Get(tt.envvar))
+			}
+			if os.Getenv(tt.envvar) != process {
+				t.Errorf("Expected the process environment to keep %q, got %q", process, os.Getenv(tt.envvar))
 			}
 
 			// Check data for each type
@@ -329,6 +361,13 @@ func assertInt64(t *testing.T, got, want int64) {
 	}
 }
 
+func assertNear(t testing.TB, got, want float64) {
+	t.Helper()
+	if math.Abs(got-want) > 0.001 {
+		t.Errorf("did not get correct value, got %v, want %v", got, want)
+	}
+}
+
 func assertStringContains(t *testing.T, full, want string) {
 	t.Helper()
 	if !strings.Contains(full, want) {
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// Events describes request arrivals counted per tick.
+// Poisson arrivals have a steady Lambda, bursty arrivals switch between
+// a quiet Lambda and a BurstLambda like a Markov-modulated Poisson process.
+type Events struct {
+	Lambda      float64 // Mean events per tick, or while quiet
+	BurstLambda float64 // Mean events per tick during a burst
+	PBurst      float64 // Chance each quiet tick starts a burst
+	PQuiet      float64 // Chance each burst tick ends the burst
+	Counter     bool    // Serve the running total instead of per-tick counts
+	Rand        *Rand   // Source of arrivals
+}
+
+// NewEventsCycBuffer creates a series of event counts.
+// As a counter the total restarts when the buffer wraps, which reads as a counter reset.
+func NewEventsCycBuffer(maxSize int, e Events, f, a string, tail int) *CycBuffer {
+	values := make([]string, 0, maxSize)
+
+	bursting := false
+	total := 0.0
+	for i := 0; i < maxSize; i++ {
+		lambda := e.Lambda
+		if a == "bursty" {
+			if bursting && e.Rand.Float64() < e.PQuiet {
+				bursting = false
+			} else if !bursting && e.Rand.Float64() < e.PBurst {
+				bursting = true
+			}
+			if bursting {
+				lambda = e.BurstLambda
+			}
+		}
+
+		count := float64(poisson(lambda, e.Rand))
+		if e.Counter {
+			total += count
+			count = total
+		}
+		values = append(values, FormatValue(count, f, tail))
+	}
+
+	return &CycBuffer{
+		NType:   f,
+		MAlgo:   a,
+		Values:  values,
+		MaxSize: maxSize,
+		Index:   0,
+		Rand:    e.Rand,
+	}
+}
+
+// poisson draws a Poisson count, using a normal approximation for large means
+func poisson(lambda float64, rng *Rand) int {
+	if lambda <= 0 {
+		return 0
+	}
+	if lambda > 30 {
+		return max(int(math.Round(lambda+math.Sqrt(lambda)*rng.NormFloat64())), 0)
+	}
+
+	limit := math.Exp(-lambda)
+	count := 0
+	for p := rng.Float64(); p > limit; p *= rng.Float64() {
+		count++
+	}
+	return count
+}
+
+// events reads arrival rates from the series parameters.
+// Lambda defaults to Limit * Mod, a burst is ten times busier.
+func (sc *SeriesConfig) events() Events {
+	lambda := sc.param("lambda", float64(sc.Limit)*sc.Mod)
+	return Events{
+		Lambda:      lambda,
+		BurstLambda: sc.param("burst_lambda", 10*lambda),
+		PBurst:      sc.param("p_burst", 0.05),
+		PQuiet:      sc.param("p_quiet", 0.2),
+		Counter:     sc.param("counter", 0) == 1,
+		Rand:        sc.rand,
+	}
+}
+
+// validateEvents checks rates are not negative and chances are 0 to 1
+func (sc *SeriesConfig) validateEvents() error {
+	e := sc.events()
+	if e.Lambda < 0 || e.BurstLambda < 0 {
+		return fmt.Errorf("event rates for metric %s cannot be negative", sc.Name)
+	}
+	if e.PBurst < 0 || e.PBurst > 1 || e.PQuiet < 0 || e.PQuiet > 1 {
+		return fmt.Errorf("burst chances for metric %s must be 0 to 1", sc.Name)
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestNewEventsCycBuffer(t *testing.T) {
+	mean := func(values []float64) float64 {
+		sum := 0.0
+		for _, v := range values {
+			sum += v
+		}
+		return sum / float64(len(values))
+	}
+
+	tests := []struct {
+		name  string
+		algo  string
+		ev    Events
+		check func(t *testing.T, values []float64)
+	}{
+		{
+			name: "Poisson mean and variance follow lambda",
+			algo: "poisson",
+			ev:   Events{Lambda: 4},
+			check: func(t *testing.T, values []float64) {
+				m := mean(values)
+				variance := 0.0
+				for _, v := range values {
+					variance += (v - m) * (v - m)
+				}
+				variance /= float64(len(values))
+				if math.Abs(m-4) > 0.3 || math.Abs(variance-4) > 0.6 {
+					t.Errorf("Expected mean and variance near 4, got %v and %v", m, variance)
+				}
+			},
+		},
+		{
+			name: "Large lambda",
+			algo: "poisson",
+			ev:   Events{Lambda: 500},
+			check: func(t *testing.T, values []float64) {
+				if m := mean(values); math.Abs(m-500) > 5 {
+					t.Errorf("Expected mean near 500, got %v", m)
+				}
+			},
+		},
+		{
+			name: "Bursty never bursts",
+			algo: "bursty",
+			ev:   Events{Lambda: 0, BurstLambda: 100, PBurst: 0, PQuiet: 1},
+			check: func(t *testing.T, values []float64) {
+				for _, v := range values {
+					if v != 0 {
+						t.Fatalf("Expected quiet ticks only, got %v", v)
+					}
+				}
+			},
+		},
+		{
+			name: "Bursty has quiet periods and bursts",
+			algo: "bursty",
+			ev:   Events{Lambda: 1, BurstLambda: 200, PBurst: 0.05, PQuiet: 0.2},
+			check: func(t *testing.T, values []float64) {
+				quiet, burst := 0, 0
+				for _, v := range values {
+					if v < 20 {
+						quiet++
+					}
+					if v > 100 {
+						burst++
+					}
+				}
+				if quiet < len(values)/2 || burst == 0 {
+					t.Errorf("Expected mostly quiet with bursts, got %d quiet and %d burst ticks", quiet, burst)
+				}
+			},
+		},
+		{
+			name: "Counter never decreases",
+			algo: "poisson",
+			ev:   Events{Lambda: 3, Counter: true},
+			check: func(t *testing.T, values []float64) {
+				for i := 1; i < len(values); i++ {
+					if values[i] < values[i-1] {
+						t.Fatalf("Expected a counter, %v follows %v", values[i], values[i-1])
+					}
+				}
+				if last := values[len(values)-1]; math.Abs(last/float64(len(values))-3) > 0.3 {
+					t.Errorf("Expected a total near %d, got %v", 3*len(values), last)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cb := NewEventsCycBuffer(2000, tt.ev, "int", tt.algo, 0)
+			assertInt(t, len(cb.Values), 2000)
+			if cb.MAlgo != tt.algo {
+				t.Errorf("Expected algo %s, got %s", tt.algo, cb.MAlgo)
+			}
+
+			values := make([]float64, len(cb.Values))
+			for i, v := range cb.Values {
+				n, err := strconv.Atoi(v)
+				assertError(t, err, nil)
+				values[i] = float64(n)
+			}
+			tt.check(t, values)
+		})
+	}
+}
+
+func TestSeriesConfig_Events(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  SeriesConfig
+		wantErr bool
+	}{
+		{name: "Poisson", config: SeriesConfig{Name: "arrivals", Type: "int", Algo: "poisson", Params: map[string]float64{"lambda": 5, "counter": 1}}},
+		{name: "Bursty", config: SeriesConfig{Name: "arrivals", Type: "int", Algo: "bursty", Params: map[string]float64{"p_burst": 0.1}}},
+		{name: "Negative lambda", config: SeriesConfig{Name: "arrivals", Type: "int", Algo: "poisson", Params: map[string]float64{"lambda": -1}}, wantErr: true},
+		{name: "Chance over 1", config: SeriesConfig{Name: "arrivals", Type: "int", Algo: "bursty", Params: map[string]float64{"p_quiet": 2}}, wantErr: true},
+		{name: "Burst param on poisson", config: SeriesConfig{Name: "arrivals", Type: "int", Algo: "poisson", Params: map[string]float64{"p_burst": 0.1}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := NewMetric(tt.config)
+			if tt.wantErr {
+				assertGotError(t, err)
+				return
+			}
+			assertError(t, err, nil)
+			assertInt(t, len(m.Buffer.Values), defSize)
+		})
+	}
+}
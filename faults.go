@@ -0,0 +1,54 @@
+package main
+
+import "fmt"
+
+// Faults simulate a misbehaving exporter for one series.
+// Each fault repeats every Every ticks and lasts the final For ticks of that period,
+// so a series with omit every 60 for 5 is missing for ticks 55 to 59, 115 to 119...
+// Built-in series take faults from the series API, until the next reset of their type.
+type Faults struct {
+	OmitEvery   int `json:"omit_every,omitempty"`   // Period of leaving the series out of responses
+	OmitFor     int `json:"omit_for,omitempty"`     // Ticks the series is left out, at the end of each period
+	FreezeEvery int `json:"freeze_every,omitempty"` // Period of the series not advancing, serving a stale value
+	FreezeFor   int `json:"freeze_for,omitempty"`   // Ticks the series is frozen, at the end of each period
+	EmptyEvery  int `json:"empty_every,omitempty"`  // Period of answering requests that include the series with an empty body
+	EmptyFor    int `json:"empty_for,omitempty"`    // Ticks responses are empty, at the end of each period
+}
+
+// Validate checks every window fits in its period
+func (f Faults) Validate() error {
+	windows := map[string][2]int{
+		"omit":   {f.OmitEvery, f.OmitFor},
+		"freeze": {f.FreezeEvery, f.FreezeFor},
+		"empty":  {f.EmptyEvery, f.EmptyFor},
+	}
+	for name, w := range windows {
+		every, length := w[0], w[1]
+		if every < 0 || length < 0 || length > every {
+			return fmt.Errorf("%s_for must be 0 to %s_every", name, name)
+		}
+	}
+	return nil
+}
+
+// Omitted is true when the series is left out at the tick
+func (f Faults) Omitted(tick uint64) bool {
+	return inWindow(tick, f.OmitEvery, f.OmitFor)
+}
+
+// Frozen is true when the series does not advance at the tick
+func (f Faults) Frozen(tick uint64) bool {
+	return inWindow(tick, f.FreezeEvery, f.FreezeFor)
+}
+
+// Empty is true when responses with the series are empty at the tick
+func (f Faults) Empty(tick uint64) bool {
+	return inWindow(tick, f.EmptyEvery, f.EmptyFor)
+}
+
+func inWindow(tick uint64, every, length int) bool {
+	if every <= 0 || length <= 0 {
+		return false
+	}
+	return tick%uint64(every) >= uint64(every-length)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFaults_Windows(t *testing.T) {
+	f := Faults{OmitEvery: 5, OmitFor: 2, FreezeEvery: 3, FreezeFor: 1}
+
+	var omitted, frozen, empty []uint64
+	for tick := uint64(0); tick < 10; tick++ {
+		if f.Omitted(tick) {
+			omitted = append(omitted, tick)
+		}
+		if f.Frozen(tick) {
+			frozen = append(frozen, tick)
+		}
+		if f.Empty(tick) {
+			empty = append(empty, tick)
+		}
+	}
+
+	assertTicks := func(name string, got, want []uint64) {
+		t.Helper()
+		if len(got) != len(want) {
+			t.Fatalf("Expected %s at %v, got %v", name, want, got)
+		}
+		for i := range got {
+			if got[i] != want[i] {
+				t.Fatalf("Expected %s at %v, got %v", name, want, got)
+			}
+		}
+	}
+	assertTicks("omitted", omitted, []uint64{3, 4, 8, 9})
+	assertTicks("frozen", frozen, []uint64{2, 5, 8})
+	assertTicks("empty", empty, nil)
+}
+
+func TestFaults_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		faults  Faults
+		wantErr bool
+	}{
+		{name: "None", faults: Faults{}},
+		{name: "Every tick", faults: Faults{EmptyEvery: 4, EmptyFor: 4}},
+		{name: "Longer than the period", faults: Faults{OmitEvery: 3, OmitFor: 4}, wantErr: true},
+		{name: "Without a period", faults: Faults{FreezeFor: 2}, wantErr: true},
+		{name: "Negative", faults: Faults{EmptyEvery: -1}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.faults.Validate()
+			if tt.wantErr {
+				assertGotError(t, err)
+				return
+			}
+			assertError(t, err, nil)
+		})
+	}
+}
+
+func TestEPHandle_Faults(t *testing.T) {
+	eph := NewEPHandle([]string{"exp", "float", "int"}, []string{"up", "down"})
+	defer eph.Ticker.Stop()
+	mux := eph.SetupMux()
+
+	get := func(target string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", target, nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w
+	}
+
+	for _, sc := range []SeriesConfig{
+		{Name: "omitted", Type: "int", Algo: "up", Faults: Faults{OmitEvery: 2, OmitFor: 1}},
+		{Name: "frozen", Type: "int", Algo: "up", Faults: Faults{FreezeEvery: 3, FreezeFor: 2}},
+		{Name: "empty", Type: "int", Algo: "up", Faults: Faults{EmptyEvery: 4, EmptyFor: 1}},
+	} {
+		assertError(t, eph.AddMetric(sc), nil)
+	}
+
+	var frozen []string
+	for tick := uint64(1); tick <= 4; tick++ {
+		eph.Advance()
+
+		body := get("/metrics").Body.String()
+		if tick == 3 {
+			if body != "" {
+				t.Errorf("Expected an empty body at tick 3, got %q", body)
+			}
+		} else if strings.Contains(body, "omitted: ") != (tick%2 == 0) {
+			t.Errorf("Expected omitted to be present only on even ticks, tick %d got %q", tick, body)
+		}
+
+		w := get("/series/omitted")
+		if tick%2 == 1 {
+			assertStatus(t, w.Code, http.StatusNotFound)
+		} else {
+			assertStatus(t, w.Code, http.StatusOK)
+		}
+
+		sample, _ := eph.Snapshot().Lookup("frozen")
+		frozen = append(frozen, sample.Value)
+	}
+
+	// Ticks 1 and 2 are frozen, tick 4 is frozen again
+	if frozen[0] != frozen[1] || frozen[2] == frozen[1] || frozen[3] != frozen[2] {
+		t.Errorf("Expected frozen values to hold on ticks 1, 2 and 4, got %v", frozen)
+	}
+}
+
+func TestEPHandle_BuiltinFaults(t *testing.T) {
+	t.Setenv("INT_SIZE", "")
+	eph := NewEPHandle([]string{"exp", "float", "int"}, []string{"up", "down"})
+	defer eph.Ticker.Stop()
+	mux := eph.SetupMux()
+
+	assertStatus(t, serve(t, mux, "PATCH", seriesPath("Metric_int_up"), `{"faults": {"omit_every": 2, "omit_for": 1}}`).Code, http.StatusOK)
+	assertStatus(t, serve(t, mux, "PATCH", seriesPath("Metric_int_down"), `{"faults": {"freeze_every": 3, "freeze_for": 2}}`).Code, http.StatusOK)
+	assertStatus(t, serve(t, mux, "PATCH", seriesPath("Metric_int_down"), `{"faults": {"freeze_every": 1, "freeze_for": 2}}`).Code, http.StatusBadRequest)
+
+	var frozen []string
+	for tick := uint64(1); tick <= 4; tick++ {
+		eph.Advance()
+
+		body := serve(t, mux, "GET", "/metrics", "").Body.String()
+		if strings.Contains(body, "Metric_int_up: ") != (tick%2 == 0) {
+			t.Errorf("Expected Metric_int_up to be present only on even ticks, tick %d got %q", tick, body)
+		}
+		w := serve(t, mux, "GET", "/series/int/up", "")
+		if tick%2 == 1 {
+			assertStatus(t, w.Code, http.StatusNotFound)
+		} else {
+			assertStatus(t, w.Code, http.StatusOK)
+		}
+
+		sample, _ := eph.Snapshot().Lookup("Metric_int_down")
+		frozen = append(frozen, sample.Value)
+	}
+
+	// Ticks 1 and 2 are frozen, tick 4 is frozen again
+	if frozen[0] != frozen[1] || frozen[2] == frozen[1] || frozen[3] != frozen[2] {
+		t.Errorf("Expected frozen values to hold on ticks 1, 2 and 4, got %v", frozen)
+	}
+
+	t.Run("Reset drops faults", func(t *testing.T) {
+		assertStatus(t, serve(t, mux, "POST", "/reset/INT_SIZE/5", "").Code, http.StatusOK)
+		eph.Advance() // Tick 5 would be omitted
+		assertStatus(t, serve(t, mux, "GET", "/series/int/up", "").Code, http.StatusOK)
+	})
+}
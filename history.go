@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defHistory     = 120             // Values kept per buffer
+	maxQueryPoints = 11000           // Same limit as Prometheus
+	queryLookback  = 5 * time.Minute // Oldest value used for a step, same as Prometheus
+)
+
+// Point is a value as it was served at one tick
+type Point struct {
+	Time  time.Time
+	Tick  uint64
+	Value string
+}
+
+// History is a ring of the most recent points emitted by a buffer
+type History struct {
+	MU     sync.Mutex
+	Points []Point // Ring storage, grows until it reaches Size
+	Size   int     // How many points are kept
+	Next   int     // Where the next point is written once full
+}
+
+// NewHistory returns a ring of the given size, or nil when size is zero
+func NewHistory(size int) *History {
+	if size <= 0 {
+		return nil
+	}
+	return &History{Size: size}
+}
+
+// Record adds a point, overwriting the oldest when full
+func (h *History) Record(p Point) {
+	h.MU.Lock()
+	defer h.MU.Unlock()
+
+	if len(h.Points) < h.Size {
+		h.Points = append(h.Points, p)
+		return
+	}
+	h.Points[h.Next] = p
+	h.Next = (h.Next + 1) % h.Size
+}
+
+// Last returns up to n of the most recent points, oldest first
+func (h *History) Last(n int) []Point {
+	h.MU.Lock()
+	defer h.MU.Unlock()
+
+	ordered := make([]Point, 0, len(h.Points))
+	ordered = append(ordered, h.Points[h.Next:]...)
+	ordered = append(ordered, h.Points[:h.Next]...)
+
+	if n > 0 && n < len(ordered) {
+		ordered = ordered[len(ordered)-n:]
+	}
+	return ordered
+}
+
+// pointAt returns the latest of the ordered points at or before t,
+// within the lookback window
+func pointAt(points []Point, t time.Time) (Point, bool) {
+	i := sort.Search(len(points), func(i int) bool {
+		return points[i].Time.After(t)
+	})
+	if i == 0 || t.Sub(points[i-1].Time) > queryLookback {
+		return Point{}, false
+	}
+	return points[i-1], true
+}
+
+// HistoryHandler returns the most recent values of a series, oldest first.
+// Each line is the series, value, and Unix timestamp in milliseconds.
+func (eph *EPHandle) HistoryHandler(w http.ResponseWriter, r *http.Request, id string) {
+	sample, ok := eph.Snapshot().Lookup(id)
+	if !ok || sample.History == nil {
+		slog.Error("No history for series: " + id)
+		http.Error(w, "No history for series: "+id, http.StatusNotFound)
+		return
+	}
+
+	last := 0
+	if l := r.URL.Query().Get("last"); l != "" {
+		var err error
+		last, err = strconv.Atoi(l)
+		if err != nil || last < 0 {
+			slog.Error("Invalid history length: " + l)
+			http.Error(w, "Invalid history length: "+l, http.StatusBadRequest)
+			return
+		}
+	}
+
+	points := sample.History.Last(last)
+
+	slog.Info("History match",
+		slog.String("method", r.Method),
+		slog.String("request", r.RequestURI),
+		slog.String("remote_addr", r.RemoteAddr),
+		slog.String("series", id),
+		slog.Int("points", len(points)))
+
+	var output strings.Builder
+	for _, p := range points {
+		fmt.Fprintf(&output, "%s: %s %d\n", id, p.Value, p.Time.UnixMilli())
+	}
+
+	w.Header().Set("Content-Type", "application/plaintext; charset=utf-8")
+	w.Write([]byte(output.String()))
+}
+
+// Prometheus API response envelope
+type queryResponse struct {
+	Status    string     `json:"status"`
+	Data      *queryData `json:"data,omitempty"`
+	ErrorType string     `json:"errorType,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+type queryData struct {
+	ResultType string        `json:"resultType"`
+	Result     []queryMatrix `json:"result"`
+}
+
+type queryMatrix struct {
+	Metric map[string]string `json:"metric"`
+	Values [][2]any          `json:"values"`
+}
+
+// QueryRangeHandler answers a Prometheus style range query from series history.
+// The query is a series selector, e.g.: http_requests{code="200"}
+// Values are evaluated at every step from start to end,
+// using the latest value at or before each step.
+func (eph *EPHandle) QueryRangeHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	name, matchers, err := parseSelector(q.Get("query"))
+	if err != nil {
+		writeQueryError(w, err)
+		return
+	}
+	start, err := parseQueryTime(q.Get("start"))
+	if err != nil {
+		writeQueryError(w, fmt.Errorf("invalid start: %w", err))
+		return
+	}
+	end, err := parseQueryTime(q.Get("end"))
+	if err != nil {
+		writeQueryError(w, fmt.Errorf("invalid end: %w", err))
+		return
+	}
+	step, err := parseQueryStep(q.Get("step"))
+	if err != nil {
+		writeQueryError(w, fmt.Errorf("invalid step: %w", err))
+		return
+	}
+	if end.Before(start) {
+		writeQueryError(w, fmt.Errorf("end is before start"))
+		return
+	}
+	if end.Sub(start)/step > maxQueryPoints {
+		writeQueryError(w, fmt.Errorf("exceeded maximum resolution of %d points per series", maxQueryPoints))
+		return
+	}
+
+	result := make([]queryMatrix, 0)
+	for _, sample := range eph.Snapshot().Match(name, matchers) {
+		if sample.History == nil {
+			continue
+		}
+
+		points := sample.History.Last(0)
+		matrix := queryMatrix{
+			Metric: map[string]string{"__name__": sample.Name},
+			Values: make([][2]any, 0),
+		}
+		for k, v := range sample.Labels {
+			matrix.Metric[k] = v
+		}
+		for t := start; !t.After(end); t = t.Add(step) {
+			if p, ok := pointAt(points, t); ok {
+				ts := float64(t.UnixMilli()) / 1000
+				matrix.Values = append(matrix.Values, [2]any{ts, p.Value})
+			}
+		}
+		if len(matrix.Values) > 0 {
+			result = append(result, matrix)
+		}
+	}
+
+	slog.Info("Range query",
+		slog.String("method", r.Method),
+		slog.String("request", r.RequestURI),
+		slog.String("remote_addr", r.RemoteAddr),
+		slog.String("query", q.Get("query")),
+		slog.Int("series", len(result)))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(queryResponse{
+		Status: "success",
+		Data:   &queryData{ResultType: "matrix", Result: result},
+	})
+}
+
+func writeQueryError(w http.ResponseWriter, err error) {
+	slog.Error("Invalid range query", slog.Any("error", err))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(queryResponse{
+		Status:    "error",
+		ErrorType: "bad_data",
+		Error:     err.Error(),
+	})
+}
+
+var (
+	selectorRE = regexp.MustCompile(`^([a-zA-Z_:][a-zA-Z0-9_:]*)(?:\{(.*)\})?$`)
+	matcherRE  = regexp.MustCompile(`^\s*([a-zA-Z_][a-zA-Z0-9_]*)\s*=\s*"((?:[^"\\]|\\.)*)"\s*(?:,|$)`)
+)
+
+// parseSelector splits name{label="value",...} into a name and label matchers.
+// Only equality matchers are supported.
+func parseSelector(query string) (string, map[string]string, error) {
+	m := selectorRE.FindStringSubmatch(strings.TrimSpace(query))
+	if m == nil {
+		return "", nil, fmt.Errorf("invalid series selector: %q", query)
+	}
+
+	matchers := make(map[string]string)
+	rest := m[2]
+	for strings.TrimSpace(rest) != "" {
+		lm := matcherRE.FindStringSubmatch(rest)
+		if lm == nil {
+			return "", nil, fmt.Errorf("invalid label matcher in %q", query)
+		}
+		value, err := strconv.Unquote(`"` + lm[2] + `"`)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid label value in %q", query)
+		}
+		matchers[lm[1]] = value
+		rest = rest[len(lm[0]):]
+	}
+
+	return m[1], matchers, nil
+}
+
+// Times are Unix seconds (with optional fraction) or RFC3339
+func parseQueryTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, fmt.Errorf("missing time")
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		sec, frac := math.Modf(f)
+		return time.Unix(int64(sec), int64(frac*1e9)), nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+// Steps are seconds (with optional fraction) or a Go duration
+func parseQueryStep(s string) (time.Duration, error) {
+	var step time.Duration
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		step = time.Duration(f * float64(time.Second))
+	} else if d, err := time.ParseDuration(s); err == nil {
+		step = d
+	} else {
+		return 0, fmt.Errorf("cannot parse %q", s)
+	}
+	if step <= 0 {
+		return 0, fmt.Errorf("step must be positive")
+	}
+	return step, nil
+}
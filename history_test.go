@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistory_Record(t *testing.T) {
+	h := NewHistory(3)
+	for i := 0; i < 5; i++ {
+		h.Record(Point{Tick: uint64(i), Value: strconv.Itoa(i)})
+	}
+
+	t.Run("Keeps the most recent points in order", func(t *testing.T) {
+		got := h.Last(0)
+		assertInt(t, len(got), 3)
+		for i, p := range got {
+			assertInt(t, int(p.Tick), i+2)
+		}
+	})
+
+	t.Run("Limits to the last n", func(t *testing.T) {
+		got := h.Last(2)
+		assertInt(t, len(got), 2)
+		assertInt(t, int(got[1].Tick), 4)
+	})
+
+	t.Run("Zero size disables history", func(t *testing.T) {
+		if NewHistory(0) != nil {
+			t.Errorf("Expected nil history")
+		}
+	})
+}
+
+func TestParseSelector(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		wantName string
+		want     map[string]string
+		wantErr  bool
+	}{
+		{name: "Metric name", query: "Metric_int_up", wantName: "Metric_int_up", want: map[string]string{}},
+		{name: "One matcher", query: `http_requests{code="200"}`, wantName: "http_requests", want: map[string]string{"code": "200"}},
+		{name: "Two matchers", query: `http_requests{code="200", method="GET"}`, wantName: "http_requests", want: map[string]string{"code": "200", "method": "GET"}},
+		{name: "Escaped quote", query: `up{path="a\"b"}`, wantName: "up", want: map[string]string{"path": `a"b`}},
+		{name: "Empty", query: "", wantErr: true},
+		{name: "Regex matcher", query: `up{code=~"2.."}`, wantErr: true},
+		{name: "Unclosed", query: `up{code="200"`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, matchers, err := parseSelector(tt.query)
+			if tt.wantErr {
+				assertGotError(t, err)
+				return
+			}
+			assertError(t, err, nil)
+			if name != tt.wantName {
+				t.Errorf("Expected name %s, got %s", tt.wantName, name)
+			}
+			assertInt(t, len(matchers), len(tt.want))
+			for k, v := range tt.want {
+				if matchers[k] != v {
+					t.Errorf("Expected %s=%s, got %s", k, v, matchers[k])
+				}
+			}
+		})
+	}
+}
+
+func TestEPHandle_HistoryHandler(t *testing.T) {
+	eph := NewEPHandle([]string{"exp", "float", "int"}, []string{"up", "down"})
+	defer eph.Ticker.Stop()
+	mux := eph.SetupMux()
+
+	for i := 0; i < 4; i++ {
+		eph.Advance()
+	}
+
+	tests := []struct {
+		name     string
+		target   string
+		wantCode int
+		lines    int
+	}{
+		{name: "Full history", target: "/series/int/up/history", wantCode: http.StatusOK, lines: 5},
+		{name: "Last two", target: "/series/int/up/history?last=2", wantCode: http.StatusOK, lines: 2},
+		{name: "Invalid length", target: "/series/int/up/history?last=two", wantCode: http.StatusBadRequest},
+		{name: "Unknown series", target: "/series/int/sideways/history", wantCode: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.target, nil)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
+			assertStatus(t, w.Code, tt.wantCode)
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+			assertInt(t, len(lines), tt.lines)
+			assertStringContains(t, lines[0], "Metric_int_up: ")
+		})
+	}
+}
+
+func TestEPHandle_QueryRangeHandler(t *testing.T) {
+	eph := NewEPHandle([]string{"exp", "float", "int"}, []string{"up", "down"})
+	defer eph.Ticker.Stop()
+	mux := eph.SetupMux()
+
+	err := eph.AddMetric(SeriesConfig{Name: "http_requests", Labels: map[string]string{"code": "200"}, Type: "int", Algo: "up"})
+	assertError(t, err, nil)
+	eph.Advance()
+
+	now := time.Now()
+	start := strconv.FormatInt(now.Add(-time.Minute).Unix(), 10)
+	end := strconv.FormatInt(now.Add(time.Minute).Unix(), 10)
+
+	tests := []struct {
+		name     string
+		query    string
+		start    string
+		end      string
+		step     string
+		wantCode int
+		series   int
+	}{
+		{name: "Built-in series", query: "Metric_int_up", start: start, end: end, step: "15s", wantCode: http.StatusOK, series: 1},
+		{name: "User-defined series", query: `http_requests{code="200"}`, start: start, end: end, step: "15", wantCode: http.StatusOK, series: 1},
+		{name: "No match", query: `http_requests{code="500"}`, start: start, end: end, step: "15", wantCode: http.StatusOK, series: 0},
+		{name: "Before history", query: "Metric_int_up", start: "0", end: "60", step: "15", wantCode: http.StatusOK, series: 0},
+		{name: "Missing step", query: "Metric_int_up", start: start, end: end, wantCode: http.StatusBadRequest},
+		{name: "End before start", query: "Metric_int_up", start: end, end: start, step: "15", wantCode: http.StatusBadRequest},
+		{name: "Too many points", query: "Metric_int_up", start: start, end: end, step: "1ms", wantCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := url.Values{"query": {tt.query}, "start": {tt.start}, "end": {tt.end}, "step": {tt.step}}
+			r := httptest.NewRequest("GET", "/api/v1/query_range?"+q.Encode(), nil)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
+			assertStatus(t, w.Code, tt.wantCode)
+
+			var resp queryResponse
+			err := json.Unmarshal(w.Body.Bytes(), &resp)
+			assertError(t, err, nil)
+			if tt.wantCode != http.StatusOK {
+				if resp.Status != "error" {
+					t.Errorf("Expected error status, got %s", resp.Status)
+				}
+				return
+			}
+			if resp.Data.ResultType != "matrix" {
+				t.Errorf("Expected matrix, got %s", resp.Data.ResultType)
+			}
+			assertInt(t, len(resp.Data.Result), tt.series)
+			for _, m := range resp.Data.Result {
+				if len(m.Values) == 0 {
+					t.Errorf("Expected values for %v", m.Metric)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Handler label of requests that match no route, answered with 404 or 405
+const unmatchedHandler = "unmatched"
+
+// Upper bounds in seconds of the internal latency histograms
+var latencyBuckets = []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+// Instruments measure toadlester itself, served on /internal/metrics
+type Instruments struct {
+	MU          sync.Mutex
+	Requests    map[requestKey]uint64        // Requests by handler and status code
+	Latency     map[string]*latencyHistogram // Request duration by handler
+	Ticks       latencyHistogram             // Duration of each Advance
+	Resets      atomic.Uint64                // Successful resets
+	MissedTicks atomic.Uint64                // Ticks skipped because an Advance ran late
+}
+
+type requestKey struct {
+	handler string
+	code    int
+}
+
+// latencyHistogram counts durations in cumulative buckets, not safe for concurrent use
+type latencyHistogram struct {
+	Counts []uint64 // Per bucket of latencyBuckets, the last is +Inf
+	Sum    float64
+	Count  uint64
+}
+
+func (h *latencyHistogram) observe(d time.Duration) {
+	if h.Counts == nil {
+		h.Counts = make([]uint64, len(latencyBuckets)+1)
+	}
+	seconds := d.Seconds()
+	bucket, _ := slices.BinarySearch(latencyBuckets, seconds)
+	h.Counts[bucket]++
+	h.Sum += seconds
+	h.Count++
+}
+
+// write renders the histogram in the Prometheus text format
+func (h *latencyHistogram) write(out *strings.Builder, name, labels string) {
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+	cumulative := uint64(0)
+	for i := 0; i <= len(latencyBuckets); i++ {
+		bound := "+Inf"
+		if i < len(latencyBuckets) {
+			bound = FormatValue(latencyBuckets[i], "float", -1)
+		}
+		if h.Counts != nil {
+			cumulative += h.Counts[i]
+		}
+		fmt.Fprintf(out, "%s_bucket{%s%sle=%q} %d\n", name, labels, sep, bound, cumulative)
+	}
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(out, "%s_sum%s %v\n", name, labels, h.Sum)
+	fmt.Fprintf(out, "%s_count%s %d\n", name, labels, h.Count)
+}
+
+// NewInstruments starts every measurement at zero
+func NewInstruments() *Instruments {
+	return &Instruments{
+		Requests: make(map[requestKey]uint64),
+		Latency:  make(map[string]*latencyHistogram),
+	}
+}
+
+// observeRequest counts a request and its duration
+func (in *Instruments) observeRequest(handler string, code int, d time.Duration) {
+	in.MU.Lock()
+	defer in.MU.Unlock()
+
+	in.Requests[requestKey{handler, code}]++
+	if in.Latency[handler] == nil {
+		in.Latency[handler] = &latencyHistogram{}
+	}
+	in.Latency[handler].observe(d)
+}
+
+// observeTick records an Advance, counting the ticks missed since the last one
+func (in *Instruments) observeTick(d, gap time.Duration) {
+	in.MU.Lock()
+	in.Ticks.observe(d)
+	in.MU.Unlock()
+
+	if missed := int64(gap/TickInterval) - 1; missed > 0 {
+		in.MissedTicks.Add(uint64(missed))
+	}
+}
+
+// statusRecorder keeps the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (sr *statusRecorder) WriteHeader(code int) {
+	sr.code = code
+	sr.ResponseWriter.WriteHeader(code)
+}
+
+// instrument is middleware that measures every request,
+// labelled by the route template so paths with values share a handler.
+// Requests that match no route share one label, so unknown paths cannot grow the counters.
+func (eph *EPHandle) instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sr := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+		next.ServeHTTP(sr, r)
+
+		handler := unmatchedHandler
+		if route := mux.CurrentRoute(r); route != nil {
+			if tpl, err := route.GetPathTemplate(); err == nil {
+				handler = tpl
+			}
+		}
+		eph.Internal.observeRequest(handler, sr.code, time.Since(start))
+	})
+}
+
+// InternalMetricsHandler serves the instruments in the Prometheus text format
+func (eph *EPHandle) InternalMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	var out strings.Builder
+	in := eph.Internal
+	snap := eph.Snapshot()
+
+	in.MU.Lock()
+	keys := make([]requestKey, 0, len(in.Requests))
+	for k := range in.Requests {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b requestKey) int {
+		if c := strings.Compare(a.handler, b.handler); c != 0 {
+			return c
+		}
+		return a.code - b.code
+	})
+
+	out.WriteString("# HELP toadlester_http_requests_total Requests served by handler and status code.\n")
+	out.WriteString("# TYPE toadlester_http_requests_total counter\n")
+	for _, k := range keys {
+		fmt.Fprintf(&out, "toadlester_http_requests_total{code=\"%d\",handler=%q} %d\n", k.code, k.handler, in.Requests[k])
+	}
+
+	out.WriteString("# HELP toadlester_http_request_duration_seconds Time spent serving requests by handler.\n")
+	out.WriteString("# TYPE toadlester_http_request_duration_seconds histogram\n")
+	handlers := make([]string, 0, len(in.Latency))
+	for h := range in.Latency {
+		handlers = append(handlers, h)
+	}
+	slices.Sort(handlers)
+	for _, h := range handlers {
+		in.Latency[h].write(&out, "toadlester_http_request_duration_seconds", fmt.Sprintf("handler=%q", h))
+	}
+
+	out.WriteString("# HELP toadlester_tick_duration_seconds Time spent advancing every buffer each tick.\n")
+	out.WriteString("# TYPE toadlester_tick_duration_seconds histogram\n")
+	in.Ticks.write(&out, "toadlester_tick_duration_seconds", "")
+	in.MU.Unlock()
+
+	out.WriteString("# HELP toadlester_missed_ticks_total Ticks skipped because advancing ran late.\n")
+	out.WriteString("# TYPE toadlester_missed_ticks_total counter\n")
+	fmt.Fprintf(&out, "toadlester_missed_ticks_total %d\n", in.MissedTicks.Load())
+
+	out.WriteString("# HELP toadlester_resets_total Successful resets of series configuration.\n")
+	out.WriteString("# TYPE toadlester_resets_total counter\n")
+	fmt.Fprintf(&out, "toadlester_resets_total %d\n", in.Resets.Load())
+
+	out.WriteString("# HELP toadlester_series Series in the current snapshot.\n")
+	out.WriteString("# TYPE toadlester_series gauge\n")
+	fmt.Fprintf(&out, "toadlester_series %d\n", len(snap.Samples))
+
+	out.WriteString("# HELP toadlester_tick Ticks since start.\n")
+	out.WriteString("# TYPE toadlester_tick gauge\n")
+	fmt.Fprintf(&out, "toadlester_tick %d\n", snap.Tick)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(out.String()))
+}
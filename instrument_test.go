@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestEPHandle_InternalMetricsHandler(t *testing.T) {
+	eph := NewEPHandle([]string{"exp", "float", "int"}, []string{"up", "down"})
+	defer eph.Ticker.Stop()
+	t.Setenv("INT_SIZE", "")
+	mux := eph.SetupMux()
+
+	get := func(target string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", target, nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w
+	}
+
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/reset/INT_SIZE/10", nil))
+	get("/metrics")
+	get("/metrics")
+	get("/series/int/up")
+	get("/series/nope")
+	get("/toad")
+	get("/frog")
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/control/step", nil))
+
+	// A late tick, three intervals after the last
+	eph.LastTick.Store(time.Now().Add(-3 * TickInterval).UnixNano())
+	eph.Advance()
+
+	w := get("/internal/metrics")
+	assertStatus(t, w.Code, http.StatusOK)
+
+	expect := []string{
+		`toadlester_http_requests_total{code="200",handler="/metrics"} 2`,
+		`toadlester_http_requests_total{code="200",handler="/series"} 1`,
+		`toadlester_http_requests_total{code="404",handler="/series"} 1`,
+		`toadlester_http_requests_total{code="404",handler="unmatched"} 2`,
+		`toadlester_http_requests_total{code="405",handler="unmatched"} 1`,
+		`toadlester_http_request_duration_seconds_bucket{handler="/metrics",le="+Inf"} 2`,
+		`toadlester_http_request_duration_seconds_count{handler="/metrics"} 2`,
+		`toadlester_tick_duration_seconds_count 1`,
+		`toadlester_missed_ticks_total 2`,
+		`toadlester_resets_total 1`,
+		`toadlester_series 6`,
+		`toadlester_tick 1`,
+		`# TYPE toadlester_http_request_duration_seconds histogram`,
+	}
+	for _, e := range expect {
+		assertStringContains(t, w.Body.String(), e)
+	}
+}
+
+func TestLatencyHistogram(t *testing.T) {
+	var h latencyHistogram
+	h.observe(50 * time.Microsecond)
+	h.observe(3 * time.Millisecond)
+	h.observe(10 * time.Second)
+
+	assertInt64(t, int64(h.Count), 3)
+	assertInt64(t, int64(h.Counts[0]), 1)                   // le 0.0001
+	assertInt64(t, int64(h.Counts[3]), 1)                   // le 0.005
+	assertInt64(t, int64(h.Counts[len(latencyBuckets)]), 1) // +Inf
+}
@@ -1,42 +1,66 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"flag"
 	"log"
-	"net/http"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 // Global vars for easy access to reset during operation.
-// Currently, not meant to be user-configurable.
+// TickInterval is set from the command line before NewEPHandle.
 // Only use these to control NewEPHandle.
 var (
-	NTypes = []string{"exp", "float", "int"} // Numeric Types
-	MAlgos = []string{"up", "down"}          // Display Algorithms
+	NTypes       = []string{"exp", "float", "int"} // Numeric Types
+	MAlgos       = []string{"up", "down"}          // Display Algorithms
+	TickInterval = 1 * time.Second                 // How often buffers advance
 )
 
 func main() {
+	opts, err := ParseOptions(os.Args[1:], os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	logger, _ := opts.Logger(os.Stderr) // Checked by ParseOptions
+	slog.SetDefault(logger)
+	TickInterval = opts.Interval
+
 	eph := NewEPHandle(NTypes, MAlgos)
 	defer eph.Ticker.Stop()
+	eph.Auth = opts.Auth
+	if eph.TLS, err = opts.TLS.Config(); err != nil {
+		log.Fatal(err)
+	}
 
-	// Run webserver in parallel to metric creation
-	go func() {
-		addr := ":8899"
-		eph.Server = &http.Server{
-			Addr:    addr,
-			Handler: eph.SetupMux(),
+	// User-defined metrics are optional
+	if opts.Config != "" {
+		if err := eph.LoadConfig(opts.Config); err != nil {
+			log.Fatal(err)
 		}
+	}
+
+	// SIGINT and SIGTERM, e.g. from Ctrl-C or a pod being rotated, shut down cleanly
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-		if err := eph.Server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+	// More simulated targets are optional
+	var targets []TargetConfig
+	if opts.Targets != "" {
+		if targets, err = LoadTargets(opts.Targets); err != nil {
 			log.Fatal(err)
 		}
-	}()
-
-	// Main loop that creates metrics for endpoint handlers
-	for {
-		select {
-		case <-eph.Ticker.C:
-			eph.RandBuffers()  // Creates a new buffer every time for random data
-			eph.ShiftBuffers() // Creates or updates the cyclical algorithm buffer
-		}
+	}
+
+	if err := RunTargets(ctx, eph, opts.Addr, opts.ShutdownTimeout, targets); err != nil {
+		log.Fatal(err)
 	}
 }
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// StateConfig describes a metric that moves between named states.
+// Each tick the next state is drawn from the current state's row of the matrix,
+// e.g. healthy/degraded/down with [[0.9, 0.08, 0.02], [0.3, 0.6, 0.1], [0.5, 0, 0.5]]
+type StateConfig struct {
+	Name    string            `json:"name"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	States  []string          `json:"states"`
+	Values  []float64         `json:"values,omitempty"`  // Numeric value of each state, the index by default
+	Matrix  [][]float64       `json:"matrix"`            // Transition probabilities, rows sum to 1
+	Initial string            `json:"initial,omitempty"` // First state, the first listed by default
+}
+
+// StateMachine is a Markov chain evaluated once per tick.
+// It is served as a numeric metric, and as a one-hot state set named {name}_state
+// with one series per state, labelled with the metric name like OpenMetrics.
+type StateMachine struct {
+	MU        sync.Mutex
+	ID        string
+	Config    StateConfig
+	Current   int        // Index of the current state
+	History   *History   // Numeric values served
+	OneHot    []string   // Series IDs of the state set
+	OneHotHis []*History // Values served by each state set series
+	Rand      *Rand      // Source of transitions
+}
+
+// Validate checks names, states and the transition matrix
+func (sc *StateConfig) Validate() error {
+	series := SeriesConfig{Name: sc.Name, Labels: sc.Labels, Type: "int", Algo: "up"}
+	if err := series.Validate(); err != nil {
+		return err
+	}
+	if !labelNameRE.MatchString(sc.Name) {
+		return fmt.Errorf("state metric %s must also be a valid label name", sc.Name)
+	}
+	if _, ok := sc.Labels[sc.Name]; ok {
+		return fmt.Errorf("state metric %s cannot have a label named %s", sc.Name, sc.Name)
+	}
+
+	n := len(sc.States)
+	if n == 0 {
+		return fmt.Errorf("state metric %s has no states", sc.Name)
+	}
+	for i, s := range sc.States {
+		if slices.Index(sc.States, s) != i {
+			return fmt.Errorf("state metric %s repeats state %s", sc.Name, s)
+		}
+	}
+	if sc.Values != nil && len(sc.Values) != n {
+		return fmt.Errorf("state metric %s needs %d values", sc.Name, n)
+	}
+	if sc.Initial != "" && !slices.Contains(sc.States, sc.Initial) {
+		return fmt.Errorf("state metric %s has unknown initial state %s", sc.Name, sc.Initial)
+	}
+
+	if len(sc.Matrix) != n {
+		return fmt.Errorf("state metric %s needs a %dx%d matrix", sc.Name, n, n)
+	}
+	for i, row := range sc.Matrix {
+		if len(row) != n {
+			return fmt.Errorf("state metric %s needs a %dx%d matrix", sc.Name, n, n)
+		}
+		sum := 0.0
+		for _, p := range row {
+			if p < 0 {
+				return fmt.Errorf("state metric %s has a negative probability from %s", sc.Name, sc.States[i])
+			}
+			sum += p
+		}
+		if math.Abs(sum-1) > 1e-6 {
+			return fmt.Errorf("state metric %s probabilities from %s sum to %v, not 1", sc.Name, sc.States[i], sum)
+		}
+	}
+	return nil
+}
+
+// NewStateMachine validates the configuration and starts in the initial state
+func NewStateMachine(sc StateConfig) (*StateMachine, error) {
+	if err := sc.Validate(); err != nil {
+		return nil, err
+	}
+
+	sm := &StateMachine{
+		ID:        SeriesID(sc.Name, sc.Labels),
+		Config:    sc,
+		Current:   max(slices.Index(sc.States, sc.Initial), 0),
+		OneHotHis: make([]*History, len(sc.States)),
+	}
+	for _, s := range sc.States {
+		sm.OneHot = append(sm.OneHot, SeriesID(sc.Name+"_state", sm.stateLabels(s)))
+	}
+	return sm, nil
+}
+
+func (sm *StateMachine) stateLabels(state string) map[string]string {
+	labels := map[string]string{sm.Config.Name: state}
+	for k, v := range sm.Config.Labels {
+		labels[k] = v
+	}
+	return labels
+}
+
+// Step moves to the next state using the current state's transition probabilities
+func (sm *StateMachine) Step() {
+	sm.MU.Lock()
+	defer sm.MU.Unlock()
+
+	row := sm.Config.Matrix[sm.Current]
+	roll := sm.Rand.Float64()
+	for next, p := range row {
+		roll -= p
+		if roll < 0 {
+			sm.Current = next
+			return
+		}
+	}
+	// Rounding left a sliver, use the last reachable state
+	for next := len(row) - 1; next >= 0; next-- {
+		if row[next] > 0 {
+			sm.Current = next
+			return
+		}
+	}
+}
+
+// State returns the name of the current state
+func (sm *StateMachine) State() string {
+	sm.MU.Lock()
+	defer sm.MU.Unlock()
+	return sm.Config.States[sm.Current]
+}
+
+// Samples returns the numeric value followed by the one-hot state set
+func (sm *StateMachine) Samples() []Sample {
+	sm.MU.Lock()
+	current := sm.Current
+	sm.MU.Unlock()
+
+	value := strconv.Itoa(current)
+	if sm.Config.Values != nil {
+		value = strconv.FormatFloat(sm.Config.Values[current], 'f', -1, 64)
+	}
+
+	samples := []Sample{{
+		ID:      sm.ID,
+		Name:    sm.Config.Name,
+		Labels:  sm.Config.Labels,
+		NType:   "int",
+		MAlgo:   "markov",
+		Value:   value,
+		History: sm.History,
+	}}
+	for i, s := range sm.Config.States {
+		oneHot := "0"
+		if i == current {
+			oneHot = "1"
+		}
+		samples = append(samples, Sample{
+			ID:      sm.OneHot[i],
+			Name:    sm.Config.Name + "_state",
+			Labels:  sm.stateLabels(s),
+			NType:   "int",
+			MAlgo:   "markov",
+			Value:   oneHot,
+			History: sm.OneHotHis[i],
+		})
+	}
+	return samples
+}
+
+// AddStateMachine adds a state metric, its series must not exist yet
+func (eph *EPHandle) AddStateMachine(sc StateConfig) error {
+	sm, err := NewStateMachine(sc)
+	if err != nil {
+		return err
+	}
+	sm.Rand = eph.Rand
+	sm.History = NewHistory(eph.HistorySize)
+	for i := range sm.OneHotHis {
+		sm.OneHotHis[i] = NewHistory(eph.HistorySize)
+	}
+
+	snap := eph.Snapshot()
+	for _, id := range append([]string{sm.ID}, sm.OneHot...) {
+		if _, ok := snap.Lookup(id); ok {
+			return fmt.Errorf("%w: %s", ErrDuplicateMetric, id)
+		}
+	}
+
+	eph.MetricsMU.Lock()
+	eph.States = append(eph.States, sm)
+	eph.MetricsMU.Unlock()
+
+	slog.Debug("GOT STATE MACHINE",
+		slog.String("id", sm.ID),
+		slog.Any("states", sc.States))
+
+	eph.Publish()
+	return nil
+}
+
+// StepStates advances every state machine one transition
+func (eph *EPHandle) StepStates() {
+	eph.MetricsMU.RLock()
+	defer eph.MetricsMU.RUnlock()
+
+	for _, sm := range eph.States {
+		sm.Step()
+	}
+}
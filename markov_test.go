@@ -0,0 +1,162 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStateMachine_Step(t *testing.T) {
+	t.Run("Deterministic cycle", func(t *testing.T) {
+		sm, err := NewStateMachine(StateConfig{
+			Name:   "service_status",
+			States: []string{"healthy", "degraded", "down"},
+			Matrix: [][]float64{{0, 1, 0}, {0, 0, 1}, {1, 0, 0}},
+		})
+		assertError(t, err, nil)
+
+		want := []string{"degraded", "down", "healthy", "degraded"}
+		for _, w := range want {
+			sm.Step()
+			if sm.State() != w {
+				t.Errorf("Expected %s, got %s", w, sm.State())
+			}
+		}
+	})
+
+	t.Run("Absorbing state", func(t *testing.T) {
+		sm, err := NewStateMachine(StateConfig{
+			Name:    "service_status",
+			States:  []string{"healthy", "down"},
+			Matrix:  [][]float64{{0.5, 0.5}, {0, 1}},
+			Initial: "down",
+		})
+		assertError(t, err, nil)
+		for i := 0; i < 20; i++ {
+			sm.Step()
+			if sm.State() != "down" {
+				t.Errorf("Expected to stay down, got %s", sm.State())
+			}
+		}
+	})
+
+	t.Run("Long run frequency follows the matrix", func(t *testing.T) {
+		sm, err := NewStateMachine(StateConfig{
+			Name:   "coin",
+			States: []string{"heads", "tails"},
+			Matrix: [][]float64{{0.5, 0.5}, {0.5, 0.5}},
+		})
+		assertError(t, err, nil)
+		heads := 0
+		for i := 0; i < 10000; i++ {
+			sm.Step()
+			if sm.State() == "heads" {
+				heads++
+			}
+		}
+		if heads < 4500 || heads > 5500 {
+			t.Errorf("Expected about 5000 heads, got %d", heads)
+		}
+	})
+}
+
+func TestStateMachine_Samples(t *testing.T) {
+	sm, err := NewStateMachine(StateConfig{
+		Name:    "http_status_class",
+		Labels:  map[string]string{"job": "api"},
+		States:  []string{"2xx", "4xx", "5xx"},
+		Values:  []float64{200, 400, 500},
+		Matrix:  [][]float64{{1, 0, 0}, {1, 0, 0}, {1, 0, 0}},
+		Initial: "4xx",
+	})
+	assertError(t, err, nil)
+
+	samples := sm.Samples()
+	assertInt(t, len(samples), 4)
+
+	want := map[string]string{
+		`http_status_class{job="api"}`:                               "400",
+		`http_status_class_state{http_status_class="2xx",job="api"}`: "0",
+		`http_status_class_state{http_status_class="4xx",job="api"}`: "1",
+		`http_status_class_state{http_status_class="5xx",job="api"}`: "0",
+	}
+	for _, s := range samples {
+		if want[s.ID] != s.Value {
+			t.Errorf("Expected %s to be %s, got %s", s.ID, want[s.ID], s.Value)
+		}
+	}
+}
+
+func TestStateConfig_Validate(t *testing.T) {
+	states := []string{"up", "down"}
+	matrix := [][]float64{{0.9, 0.1}, {0.5, 0.5}}
+
+	tests := []struct {
+		name   string
+		config StateConfig
+	}{
+		{name: "No states", config: StateConfig{Name: "s", Matrix: matrix}},
+		{name: "Repeated state", config: StateConfig{Name: "s", States: []string{"up", "up"}, Matrix: matrix}},
+		{name: "Matrix too small", config: StateConfig{Name: "s", States: states, Matrix: [][]float64{{1}}}},
+		{name: "Row too short", config: StateConfig{Name: "s", States: states, Matrix: [][]float64{{1}, {0.5, 0.5}}}},
+		{name: "Row does not sum to 1", config: StateConfig{Name: "s", States: states, Matrix: [][]float64{{0.9, 0.2}, {0.5, 0.5}}}},
+		{name: "Negative probability", config: StateConfig{Name: "s", States: states, Matrix: [][]float64{{1.1, -0.1}, {0.5, 0.5}}}},
+		{name: "Wrong number of values", config: StateConfig{Name: "s", States: states, Matrix: matrix, Values: []float64{1}}},
+		{name: "Unknown initial", config: StateConfig{Name: "s", States: states, Matrix: matrix, Initial: "sideways"}},
+		{name: "Name is not a label", config: StateConfig{Name: "svc:status", States: states, Matrix: matrix}},
+		{name: "Label named after metric", config: StateConfig{Name: "s", Labels: map[string]string{"s": "x"}, States: states, Matrix: matrix}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewStateMachine(tt.config)
+			assertGotError(t, err)
+		})
+	}
+}
+
+func TestEPHandle_AddStateMachine(t *testing.T) {
+	eph := NewEPHandle([]string{"exp", "float", "int"}, []string{"up", "down"})
+	defer eph.Ticker.Stop()
+	mux := eph.SetupMux()
+
+	err := eph.AddStateMachine(StateConfig{
+		Name:   "service_status",
+		States: []string{"healthy", "degraded"},
+		Matrix: [][]float64{{0, 1}, {1, 0}},
+	})
+	assertError(t, err, nil)
+
+	t.Run("Rejects duplicates", func(t *testing.T) {
+		err := eph.AddStateMachine(StateConfig{Name: "service_status", States: []string{"a"}, Matrix: [][]float64{{1}}})
+		assertGotError(t, err)
+	})
+
+	eph.Advance()
+
+	tests := []struct {
+		name   string
+		target string
+		expect []string
+	}{
+		{name: "Numeric value", target: "/series/service_status", expect: []string{"service_status: 1"}},
+		{
+			name:   "State set",
+			target: "/series/service_status_state",
+			expect: []string{`service_status_state{service_status="degraded"}: 1`, `service_status_state{service_status="healthy"}: 0`},
+		},
+		{name: "Metrics page", target: "/metrics", expect: []string{"service_status: 1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.target, nil)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
+			assertStatus(t, w.Code, http.StatusOK)
+			for _, e := range tt.expect {
+				assertStringContains(t, w.Body.String(), e)
+			}
+		})
+	}
+}
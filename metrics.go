@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+var (
+	metricNameRE = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNameRE  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// ErrDuplicateMetric is returned when a series ID is already served
+var ErrDuplicateMetric = errors.New("duplicate metric")
+
+// Algorithms that can back a user-defined metric
+var SeriesAlgos = []string{"up", "down", "random", "replay", "composite", "poisson", "bursty", "lognormal", "pareto", "exponential", "step"}
+
+// Parameters accepted by each algorithm under "params"
+var AlgoParams = map[string][]string{
+	"composite":   {"base", "trend", "daily", "weekly", "noise", "tick_seconds"},
+	"poisson":     {"lambda", "counter"},
+	"bursty":      {"lambda", "burst_lambda", "p_burst", "p_quiet", "counter"},
+	"lognormal":   {"median", "p99", "observations"},
+	"pareto":      {"median", "p99", "observations"},
+	"exponential": {"median", "observations"},
+	"step":        {"hold", "baseline", "return"},
+}
+
+// Config is the JSON configuration file read at startup
+type Config struct {
+	Metrics   []SeriesConfig  `json:"metrics"`
+	Templates []Template      `json:"templates"`
+	Groups    []GroupConfig   `json:"groups"`
+	States    []StateConfig   `json:"states"`
+	Derived   []DerivedConfig `json:"derived"`
+}
+
+// SeriesConfig describes a user-defined metric.
+// Parameters have the same meaning as their Env Var counterparts,
+// zero values for Size, Limit and Mod are replaced with defaults.
+type SeriesConfig struct {
+	Name     string             `json:"name"`
+	Labels   map[string]string  `json:"labels,omitempty"`
+	Type     string             `json:"type"` // Numeric Type
+	Algo     string             `json:"algo"` // Metric Algorithm Name
+	Size     int                `json:"size,omitempty"`
+	Limit    int                `json:"limit,omitempty"`
+	Tail     int                `json:"tail,omitempty"`
+	Mod      float64            `json:"mod,omitempty"`
+	Specials Specials           `json:"specials,omitempty"`
+	File     string             `json:"file,omitempty"`    // Capture played by replay
+	Speed    float64            `json:"speed,omitempty"`   // Replay speed, 1 is the original
+	Once     bool               `json:"once,omitempty"`    // Replay stops at the end
+	Params   map[string]float64 `json:"params,omitempty"`  // Parameters of the algorithm, see AlgoParams
+	Buckets  []float64          `json:"buckets,omitempty"` // Serve a histogram of a distribution instead
+	Levels   []float64          `json:"levels,omitempty"`  // Levels a step cycles through, random when empty
+	Faults   Faults             `json:"faults,omitempty"`  // Missing, stale and empty responses
+
+	rand    *Rand // Source for the generators, set by the handle
+	history int   // Values kept in the history of each series, set by the handle
+}
+
+// Metric is a user-defined series backed by its own shift register
+type Metric struct {
+	ID       string       // Name and labels, e.g.: http_requests{code="200"}
+	Config   SeriesConfig // Configuration used to build the buffer
+	Buffer   *CycBuffer   // Values served for this metric
+	Template string       // Name of the template that generated this metric, if any
+	Group    string       // Name of the correlated group this metric belongs to, if any
+
+	Histogram *Histogram // Served instead of the buffer when the config has buckets
+}
+
+// NewMetric validates the configuration and builds its buffer
+func NewMetric(sc SeriesConfig) (*Metric, error) {
+	if err := sc.Validate(); err != nil {
+		return nil, err
+	}
+	sc.applyDefaults()
+
+	buffer, err := sc.NewBuffer()
+	if err != nil {
+		return nil, fmt.Errorf("could not build metric %s: %w", sc.Name, err)
+	}
+	buffer.ApplySpecials(sc.Specials, sc.Tail)
+	buffer.History = NewHistory(sc.history)
+
+	m := &Metric{
+		ID:     SeriesID(sc.Name, sc.Labels),
+		Config: sc,
+		Buffer: buffer,
+	}
+	if len(sc.Buckets) > 0 {
+		m.Histogram = NewHistogram(sc)
+	}
+	return m, nil
+}
+
+// isDistribution is true for algorithms that draw from a heavy-tailed distribution
+func (sc *SeriesConfig) isDistribution() bool {
+	return sc.Algo == "lognormal" || sc.Algo == "pareto" || sc.Algo == "exponential"
+}
+
+// Validate checks names, labels, type and algorithm
+func (sc *SeriesConfig) Validate() error {
+	if !metricNameRE.MatchString(sc.Name) {
+		return fmt.Errorf("invalid metric name: %q", sc.Name)
+	}
+	for k := range sc.Labels {
+		if !labelNameRE.MatchString(k) || strings.HasPrefix(k, "__") {
+			return fmt.Errorf("invalid label name %q for metric %s", k, sc.Name)
+		}
+	}
+	if !slices.Contains(NTypes, sc.Type) {
+		return fmt.Errorf("invalid type %q for metric %s", sc.Type, sc.Name)
+	}
+	if !slices.Contains(SeriesAlgos, sc.Algo) {
+		return fmt.Errorf("invalid algo %q for metric %s", sc.Algo, sc.Name)
+	}
+	if sc.Size < 0 || sc.Limit < 0 || sc.Tail < 0 {
+		return fmt.Errorf("size, limit and tail for metric %s cannot be negative", sc.Name)
+	}
+	if sc.Limit > math.MaxInt32 {
+		return fmt.Errorf("limit for metric %s cannot be over %d", sc.Name, math.MaxInt32)
+	}
+	if sc.Algo == "replay" && sc.File == "" {
+		return fmt.Errorf("replay metric %s has no file", sc.Name)
+	}
+	for k := range sc.Params {
+		if !slices.Contains(AlgoParams[sc.Algo], k) {
+			return fmt.Errorf("invalid param %q for %s metric %s", k, sc.Algo, sc.Name)
+		}
+	}
+	if sc.Algo == "composite" && sc.param("tick_seconds", defTickSeconds) <= 0 {
+		return fmt.Errorf("tick_seconds for metric %s must be positive", sc.Name)
+	}
+	if err := sc.Faults.Validate(); err != nil {
+		return fmt.Errorf("invalid faults for metric %s: %w", sc.Name, err)
+	}
+	if len(sc.Buckets) > 0 && !sc.isDistribution() {
+		return fmt.Errorf("buckets for metric %s need a distribution algo", sc.Name)
+	}
+	if len(sc.Levels) > 0 && sc.Algo != "step" {
+		return fmt.Errorf("levels for metric %s need the step algo", sc.Name)
+	}
+	if sc.Algo == "poisson" || sc.Algo == "bursty" {
+		return sc.validateEvents()
+	}
+	if sc.isDistribution() {
+		return sc.validateDistribution()
+	}
+	if sc.Algo == "step" {
+		return sc.validateStep()
+	}
+	return nil
+}
+
+// param returns an algorithm parameter, or its default when unset
+func (sc *SeriesConfig) param(name string, def float64) float64 {
+	if v, ok := sc.Params[name]; ok {
+		return v
+	}
+	return def
+}
+
+// NewBuffer builds the buffer for the configured algorithm
+func (sc *SeriesConfig) NewBuffer() (*CycBuffer, error) {
+	switch sc.Algo {
+	case "replay":
+		return NewReplayCycBuffer(sc.File, sc.Speed, sc.Once, sc.Type, sc.Tail)
+	case "composite":
+		return NewCompositeCycBuffer(sc.Size, sc.composite(), sc.Type, sc.Tail), nil
+	case "poisson", "bursty":
+		return NewEventsCycBuffer(sc.Size, sc.events(), sc.Type, sc.Algo, sc.Tail), nil
+	case "lognormal", "pareto", "exponential":
+		return NewDistributionCycBuffer(sc.Size, sc.distribution(), sc.Type, sc.Tail), nil
+	case "step":
+		return NewStepCycBuffer(sc.Size, sc.Limit, sc.Tail, sc.Mod, sc.Type, sc.step()), nil
+	default:
+		return NewShiftCycBuffer(sc.Size, sc.Limit, sc.Tail, sc.Mod, sc.Type, sc.Algo, sc.rand), nil
+	}
+}
+
+func (sc *SeriesConfig) applyDefaults() {
+	if sc.Size == 0 {
+		sc.Size = defSize
+	}
+	if sc.Limit == 0 {
+		sc.Limit = defLimit
+	}
+	if sc.Mod == 0 {
+		sc.Mod = defMod
+	}
+	if sc.Speed == 0 {
+		sc.Speed = defSpeed
+	}
+}
+
+// SeriesID renders a metric name with its labels sorted by name,
+// e.g.: http_requests{code="200",method="GET"}
+func SeriesID(name string, labels map[string]string) string {
+	if len(labels) == 0 {
+		return name
+	}
+
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", k, labels[k]))
+	}
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
+
+// LoadConfig reads a JSON configuration file and adds its metrics
+func (eph *EPHandle) LoadConfig(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read config: %w", err)
+	}
+
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("could not parse config %s: %w", path, err)
+	}
+
+	for _, sc := range config.Metrics {
+		if err := eph.AddMetric(sc); err != nil {
+			return err
+		}
+	}
+	for _, tpl := range config.Templates {
+		if err := eph.AddTemplate(tpl); err != nil {
+			return err
+		}
+	}
+	for _, g := range config.Groups {
+		if err := eph.AddGroup(g); err != nil {
+			return err
+		}
+	}
+	for _, sc := range config.States {
+		if err := eph.AddStateMachine(sc); err != nil {
+			return err
+		}
+	}
+	for _, dc := range config.Derived {
+		if err := eph.AddDerived(dc); err != nil {
+			return err
+		}
+	}
+
+	slog.Info("Config loaded",
+		slog.String("path", path),
+		slog.Int("metrics", len(config.Metrics)),
+		slog.Int("templates", len(config.Templates)),
+		slog.Int("groups", len(config.Groups)),
+		slog.Int("states", len(config.States)),
+		slog.Int("derived", len(config.Derived)))
+
+	return nil
+}
+
+// AddMetric creates a user-defined metric, IDs must be unique
+func (eph *EPHandle) AddMetric(sc SeriesConfig) error {
+	sc.rand = eph.Rand
+	sc.history = eph.HistorySize
+	metric, err := NewMetric(sc)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := eph.Snapshot().Lookup(metric.ID); ok {
+		return fmt.Errorf("%w: %s", ErrDuplicateMetric, metric.ID)
+	}
+
+	eph.MetricsMU.Lock()
+	if _, ok := eph.Metrics[metric.ID]; ok {
+		eph.MetricsMU.Unlock()
+		return fmt.Errorf("%w: %s", ErrDuplicateMetric, metric.ID)
+	}
+	eph.Metrics[metric.ID] = metric
+	eph.MetricsMU.Unlock()
+
+	slog.Debug("GOT METRIC",
+		slog.String("id", metric.ID),
+		slog.Any("buffer", metric.Buffer))
+
+	eph.Publish()
+	return nil
+}
+
+// sortedMetrics returns user-defined metrics ordered by ID.
+// Callers must hold MetricsMU.
+func (eph *EPHandle) sortedMetrics() []*Metric {
+	metrics := make([]*Metric, 0, len(eph.Metrics))
+	for _, m := range eph.Metrics {
+		metrics = append(metrics, m)
+	}
+	sort.Slice(metrics, func(i, j int) bool {
+		return metrics[i].ID < metrics[j].ID
+	})
+	return metrics
+}
+
+// matchLabels reports if every matcher is present in labels
+func matchLabels(labels map[string]string, matchers map[string]string) bool {
+	for k, v := range matchers {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSeriesID(t *testing.T) {
+	tests := []struct {
+		name   string
+		metric string
+		labels map[string]string
+		want   string
+	}{
+		{name: "No labels", metric: "up", labels: nil, want: "up"},
+		{name: "One label", metric: "up", labels: map[string]string{"job": "toad"}, want: `up{job="toad"}`},
+		{name: "Sorted labels", metric: "http_requests", labels: map[string]string{"method": "GET", "code": "200"}, want: `http_requests{code="200",method="GET"}`},
+		{name: "Escaped value", metric: "up", labels: map[string]string{"path": `a"b`}, want: `up{path="a\"b"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SeriesID(tt.metric, tt.labels)
+			if got != tt.want {
+				t.Errorf("Expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestNewMetric(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  SeriesConfig
+		wantErr bool
+	}{
+		{name: "Valid metric", config: SeriesConfig{Name: "http_requests", Labels: map[string]string{"code": "200"}, Type: "int", Algo: "up"}},
+		{name: "Invalid name", config: SeriesConfig{Name: "http-requests", Type: "int", Algo: "up"}, wantErr: true},
+		{name: "Invalid label", config: SeriesConfig{Name: "http_requests", Labels: map[string]string{"1code": "200"}, Type: "int", Algo: "up"}, wantErr: true},
+		{name: "Reserved label", config: SeriesConfig{Name: "http_requests", Labels: map[string]string{"__name__": "x"}, Type: "int", Algo: "up"}, wantErr: true},
+		{name: "Invalid type", config: SeriesConfig{Name: "http_requests", Type: "hex", Algo: "up"}, wantErr: true},
+		{name: "Invalid algo", config: SeriesConfig{Name: "http_requests", Type: "int", Algo: "sideways"}, wantErr: true},
+		{name: "Negative size", config: SeriesConfig{Name: "http_requests", Type: "int", Algo: "up", Size: -1}, wantErr: true},
+		{name: "Negative limit", config: SeriesConfig{Name: "http_requests", Type: "int", Algo: "up", Limit: -5}, wantErr: true},
+		{name: "Negative tail", config: SeriesConfig{Name: "http_requests", Type: "float", Algo: "up", Tail: -1}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metric, err := NewMetric(tt.config)
+			if tt.wantErr {
+				assertGotError(t, err)
+				return
+			}
+			assertError(t, err, nil)
+			assertInt(t, len(metric.Buffer.Values), defSize)
+		})
+	}
+}
+
+func TestEPHandle_LoadConfig(t *testing.T) {
+	eph := NewEPHandle([]string{"exp", "float", "int"}, []string{"up", "down"})
+	defer eph.Ticker.Stop()
+
+	config := `{"metrics": [
+		{"name": "http_requests", "labels": {"method": "GET", "code": "200"}, "type": "int", "algo": "up", "size": 5},
+		{"name": "http_requests", "labels": {"method": "GET", "code": "500"}, "type": "int", "algo": "down", "size": 5},
+		{"name": "queue_depth", "type": "float", "algo": "random", "tail": 2}
+	]}`
+	path := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(path, []byte(config), 0644)
+	assertError(t, err, nil)
+
+	err = eph.LoadConfig(path)
+	assertError(t, err, nil)
+	assertInt(t, len(eph.Metrics), 3)
+
+	t.Run("Rejects duplicate metrics", func(t *testing.T) {
+		err := eph.LoadConfig(path)
+		assertGotError(t, err)
+	})
+
+	t.Run("Rejects missing file", func(t *testing.T) {
+		err := eph.LoadConfig(filepath.Join(t.TempDir(), "nope.json"))
+		assertGotError(t, err)
+	})
+
+	mux := eph.SetupMux()
+	tests := []struct {
+		name     string
+		target   string
+		wantCode int
+		expect   []string
+		reject   []string
+	}{
+		{
+			name:     "Metrics page includes user-defined metrics",
+			target:   "/metrics",
+			wantCode: http.StatusOK,
+			expect:   []string{"Metric_int_up: ", `http_requests{code="200",method="GET"}: `, "queue_depth: "},
+		},
+		{
+			name:     "Series returns every label set",
+			target:   "/series/http_requests",
+			wantCode: http.StatusOK,
+			expect:   []string{`http_requests{code="200",method="GET"}: `, `http_requests{code="500",method="GET"}: `},
+		},
+		{
+			name:     "Series filters by label",
+			target:   "/series/http_requests?code=500",
+			wantCode: http.StatusOK,
+			expect:   []string{`http_requests{code="500",method="GET"}: `},
+			reject:   []string{`code="200"`},
+		},
+		{
+			name:     "Series without a match",
+			target:   "/series/http_requests?code=404",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Series with an unknown metric",
+			target:   "/series/nope",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.target, nil)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
+			assertStatus(t, w.Code, tt.wantCode)
+			for _, e := range tt.expect {
+				assertStringContains(t, w.Body.String(), e)
+			}
+			for _, e := range tt.reject {
+				if strings.Contains(w.Body.String(), e) {
+					t.Errorf("Did not expect %q in %q", e, w.Body.String())
+				}
+			}
+		})
+	}
+
+	t.Run("Shifting advances user-defined metrics", func(t *testing.T) {
+		m := eph.Metrics[`http_requests{code="200",method="GET"}`]
+		before := m.Buffer.Index
+		eph.ShiftBuffers()
+		assertInt(t, m.Buffer.Index, (before+1)%m.Buffer.MaxSize)
+	})
+}
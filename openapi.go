@@ -0,0 +1,91 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// openAPI describes every route in SetupMux, keep them in step
+//
+//go:embed openapi.json
+var openAPI []byte
+
+// Index lists the endpoints and the series served, for discovering the API
+type Index struct {
+	Name    string            `json:"name"`
+	Version string            `json:"version"`
+	Links   map[string]string `json:"links"`             // Endpoint paths by name
+	Targets []string          `json:"targets,omitempty"` // Paths of targets mounted under a prefix
+	Series  []IndexSeries     `json:"series"`
+}
+
+// IndexSeries is a served series with the paths that return it
+type IndexSeries struct {
+	ID    string            `json:"id"`
+	Name  string            `json:"name"`
+	Type  string            `json:"type"`
+	Algo  string            `json:"algo"`
+	Links map[string]string `json:"links"` // series, history and api when they apply
+}
+
+// OpenAPIHandler returns the OpenAPI 3 document of the endpoints
+func (eph *EPHandle) OpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPI)
+}
+
+// IndexHandler returns links to every endpoint and series.
+// Under a mount the links include its prefix.
+func (eph *EPHandle) IndexHandler(w http.ResponseWriter, r *http.Request) {
+	requested, _, _ := strings.Cut(r.RequestURI, "?")
+	prefix := strings.TrimSuffix(requested, r.URL.Path)
+
+	index := Index{
+		Name:    "toadlester",
+		Version: Build().Version,
+		Links: map[string]string{
+			"openapi":          prefix + "/openapi.json",
+			"metrics":          prefix + "/metrics",
+			"random":           prefix + "/rand/all",
+			"series":           prefix + "/api/v1/series",
+			"query_range":      prefix + "/api/v1/query_range",
+			"audit":            prefix + "/audit",
+			"healthz":          prefix + "/healthz",
+			"readyz":           prefix + "/readyz",
+			"version":          prefix + "/version",
+			"internal_metrics": prefix + "/internal/metrics",
+		},
+	}
+	for _, mount := range slices.Sorted(maps.Keys(eph.Mounts)) {
+		index.Targets = append(index.Targets, prefix+mount+"/")
+	}
+
+	snap := eph.Snapshot()
+	eph.MetricsMU.RLock()
+	for _, sample := range snap.Samples {
+		s := IndexSeries{ID: sample.ID, Name: sample.Name, Type: sample.NType, Algo: sample.MAlgo, Links: map[string]string{}}
+		api := prefix + "/api/v1/series/" + url.PathEscape(sample.ID)
+		_, managed := eph.Metrics[sample.ID]
+		switch {
+		case managed:
+			s.Links["series"] = prefix + "/series/" + sample.Name
+			s.Links["api"] = api
+		case sample.ID == builtinName(sample.NType, sample.MAlgo):
+			s.Links["series"] = prefix + "/series/" + sample.NType + "/" + sample.MAlgo
+			s.Links["history"] = s.Links["series"] + "/history"
+			s.Links["api"] = api
+		default: // Histogram parts, state machines and derived metrics
+			s.Links["series"] = prefix + "/series/" + sample.Name
+		}
+		index.Series = append(index.Series, s)
+	}
+	eph.MetricsMU.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(index)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// Path parameters filled in to check documented paths are routed
+var exampleParams = map[string]string{
+	"name":  "http_requests",
+	"type":  "int",
+	"algo":  "up",
+	"id":    "Metric_int_up",
+	"var":   "INT_SIZE",
+	"value": "10",
+}
+
+func TestOpenAPI(t *testing.T) {
+	var doc struct {
+		OpenAPI string                    `json:"openapi"`
+		Paths   map[string]map[string]any `json:"paths"`
+	}
+	assertError(t, json.Unmarshal(openAPI, &doc), nil)
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		t.Fatalf("Expected an OpenAPI 3 document, got %q", doc.OpenAPI)
+	}
+
+	eph := NewEPHandle([]string{"exp", "float", "int"}, []string{"up", "down"})
+	defer eph.Ticker.Stop()
+	router := eph.SetupMux()
+
+	t.Run("Every route is documented", func(t *testing.T) {
+		regexpParam := regexp.MustCompile(`\{(\w+):[^}]*\}`)
+		err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+			tpl, err := route.GetPathTemplate()
+			if err != nil {
+				return err
+			}
+			tpl = regexpParam.ReplaceAllString(tpl, "{$1}")
+
+			if _, ok := doc.Paths[tpl]; ok {
+				return nil
+			}
+			// Prefix routes like /series and /reset document each path below them
+			for path := range doc.Paths {
+				if strings.HasPrefix(path, tpl+"/") {
+					return nil
+				}
+			}
+			t.Errorf("Route %s is not in the OpenAPI document", tpl)
+			return nil
+		})
+		assertError(t, err, nil)
+	})
+
+	t.Run("Every documented operation is routed", func(t *testing.T) {
+		for path, item := range doc.Paths {
+			target := path
+			for k, v := range exampleParams {
+				target = strings.ReplaceAll(target, "{"+k+"}", v)
+			}
+			for method := range item {
+				if method == "parameters" {
+					continue
+				}
+				var match mux.RouteMatch
+				r := httptest.NewRequest(strings.ToUpper(method), target, nil)
+				if !router.Match(r, &match) || match.MatchErr != nil {
+					t.Errorf("Expected %s %s to be routed", strings.ToUpper(method), path)
+				}
+			}
+		}
+	})
+
+	t.Run("Is served", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/openapi.json", nil))
+		assertStatus(t, w.Code, http.StatusOK)
+		if w.Body.String() != string(openAPI) {
+			t.Error("Expected the embedded document")
+		}
+	})
+}
+
+func TestEPHandle_IndexHandler(t *testing.T) {
+	eph := NewEPHandle([]string{"exp", "float", "int"}, []string{"up", "down"})
+	defer eph.Ticker.Stop()
+	assertError(t, eph.AddMetric(SeriesConfig{Name: "http_requests", Labels: map[string]string{"code": "200"}, Type: "int", Algo: "up"}), nil)
+
+	target := NewTargetEPHandle([]string{"int"}, []string{"up"}, NewEnv(nil), nil)
+	eph.Mount("/web-1", target)
+	router := eph.SetupMux()
+
+	tests := []struct {
+		name      string
+		target    string
+		prefix    string
+		wantLinks map[string]map[string]string // Links by series ID
+	}{
+		{
+			name:   "Main",
+			target: "/",
+			wantLinks: map[string]map[string]string{
+				"Metric_int_up":             {"series": "/series/int/up", "history": "/series/int/up/history", "api": "/api/v1/series/Metric_int_up"},
+				`http_requests{code="200"}`: {"series": "/series/http_requests", "api": "/api/v1/series/http_requests%7Bcode=%22200%22%7D"},
+			},
+		},
+		{
+			name:   "Mounted target",
+			target: "/web-1/",
+			prefix: "/web-1",
+			wantLinks: map[string]map[string]string{
+				"Metric_int_up": {"series": "/web-1/series/int/up", "history": "/web-1/series/int/up/history", "api": "/web-1/api/v1/series/Metric_int_up"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", tt.target, nil))
+			assertStatus(t, w.Code, http.StatusOK)
+
+			var index Index
+			assertError(t, json.NewDecoder(w.Body).Decode(&index), nil)
+			if index.Links["openapi"] != tt.prefix+"/openapi.json" {
+				t.Errorf("Expected the OpenAPI link under %q, got %s", tt.prefix, index.Links["openapi"])
+			}
+
+			found := map[string]map[string]string{}
+			for _, s := range index.Series {
+				found[s.ID] = s.Links
+			}
+			for id, want := range tt.wantLinks {
+				for name, link := range want {
+					if found[id][name] != link {
+						t.Errorf("Expected %s link %s for %s, got %s", name, link, id, found[id][name])
+					}
+				}
+			}
+		})
+	}
+
+	t.Run("Lists mounted targets", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		var index Index
+		assertError(t, json.NewDecoder(w.Body).Decode(&index), nil)
+		if len(index.Targets) != 1 || index.Targets[0] != "/web-1/" {
+			t.Errorf("Expected target /web-1/, got %v", index.Targets)
+		}
+	})
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// Options are the command line flags, each defaulting to its Env Var
+type Options struct {
+	Addr            string        // -addr, TOAD_ADDR
+	Interval        time.Duration // -interval, TOAD_INTERVAL
+	LogLevel        string        // -log-level, TOAD_LOG_LEVEL
+	LogFormat       string        // -log-format, TOAD_LOG_FORMAT
+	Config          string        // -config, TOAD_CONFIG
+	Targets         string        // -targets, TOAD_TARGETS
+	ShutdownTimeout time.Duration // -shutdown-timeout, TOAD_SHUTDOWN_TIMEOUT
+	Auth            ControlAuth   // -control-token, -control-user, -control-password, TOAD_CONTROL_*
+	TLS             TLSOptions    // -tls-cert, -tls-key, -tls-self-signed, -tls-hosts, -tls-client-ca, -tls-fault, TOAD_TLS_*
+}
+
+// ParseOptions reads flags from args, which override Env Vars, which override defaults
+func ParseOptions(args []string, output io.Writer) (Options, error) {
+	opts := Options{
+		Addr:            envOr("TOAD_ADDR", ":8899"),
+		Interval:        FillEnvVarDuration("TOAD_INTERVAL", TickInterval),
+		LogLevel:        envOr("TOAD_LOG_LEVEL", "info"),
+		LogFormat:       envOr("TOAD_LOG_FORMAT", "text"),
+		Config:          envOr("TOAD_CONFIG", ""),
+		Targets:         envOr("TOAD_TARGETS", ""),
+		ShutdownTimeout: FillEnvVarDuration("TOAD_SHUTDOWN_TIMEOUT", defShutdownTimeout),
+		Auth: ControlAuth{
+			Token:    envOr("TOAD_CONTROL_TOKEN", ""),
+			User:     envOr("TOAD_CONTROL_USER", ""),
+			Password: envOr("TOAD_CONTROL_PASSWORD", ""),
+		},
+		TLS: TLSOptions{
+			Cert:       envOr("TOAD_TLS_CERT", ""),
+			Key:        envOr("TOAD_TLS_KEY", ""),
+			SelfSigned: envOr("TOAD_TLS_SELF_SIGNED", "") == "true",
+			Hosts:      envOr("TOAD_TLS_HOSTS", ""),
+			ClientCA:   envOr("TOAD_TLS_CLIENT_CA", ""),
+			Fault:      envOr("TOAD_TLS_FAULT", ""),
+		},
+	}
+
+	fs := flag.NewFlagSet("toadlester", flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.StringVar(&opts.Addr, "addr", opts.Addr, "listen address (TOAD_ADDR)")
+	fs.DurationVar(&opts.Interval, "interval", opts.Interval, "how often buffers advance (TOAD_INTERVAL)")
+	fs.StringVar(&opts.LogLevel, "log-level", opts.LogLevel, "debug, info, warn or error (TOAD_LOG_LEVEL)")
+	fs.StringVar(&opts.LogFormat, "log-format", opts.LogFormat, "text or json (TOAD_LOG_FORMAT)")
+	fs.StringVar(&opts.Config, "config", opts.Config, "JSON file of user-defined metrics (TOAD_CONFIG)")
+	fs.StringVar(&opts.Targets, "targets", opts.Targets, "JSON file of more simulated targets (TOAD_TARGETS)")
+	fs.DurationVar(&opts.ShutdownTimeout, "shutdown-timeout", opts.ShutdownTimeout, "time in-flight requests get to finish (TOAD_SHUTDOWN_TIMEOUT)")
+	fs.StringVar(&opts.Auth.Token, "control-token", opts.Auth.Token, "bearer token for control endpoints like /reset (TOAD_CONTROL_TOKEN)")
+	fs.StringVar(&opts.Auth.User, "control-user", opts.Auth.User, "basic auth user for control endpoints (TOAD_CONTROL_USER)")
+	fs.StringVar(&opts.Auth.Password, "control-password", opts.Auth.Password, "basic auth password for control endpoints (TOAD_CONTROL_PASSWORD)")
+	fs.StringVar(&opts.TLS.Cert, "tls-cert", opts.TLS.Cert, "PEM certificate file to serve HTTPS (TOAD_TLS_CERT)")
+	fs.StringVar(&opts.TLS.Key, "tls-key", opts.TLS.Key, "PEM private key file for -tls-cert (TOAD_TLS_KEY)")
+	fs.BoolVar(&opts.TLS.SelfSigned, "tls-self-signed", opts.TLS.SelfSigned, "serve HTTPS with a certificate generated at startup (TOAD_TLS_SELF_SIGNED)")
+	fs.StringVar(&opts.TLS.Hosts, "tls-hosts", opts.TLS.Hosts, "comma separated names and IPs of a generated certificate, "+defTLSHosts+" by default (TOAD_TLS_HOSTS)")
+	fs.StringVar(&opts.TLS.ClientCA, "tls-client-ca", opts.TLS.ClientCA, "PEM CA file, clients must present a certificate it signed (TOAD_TLS_CLIENT_CA)")
+	fs.StringVar(&opts.TLS.Fault, "tls-fault", opts.TLS.Fault, "serve a generated certificate that is expired or wrong-host (TOAD_TLS_FAULT)")
+	if err := fs.Parse(args); err != nil {
+		return opts, err
+	}
+	if fs.NArg() > 0 {
+		return opts, fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+
+	if opts.Auth.User != "" && opts.Auth.Password == "" {
+		return opts, fmt.Errorf("control user %s needs a password", opts.Auth.User)
+	}
+	if err := opts.TLS.Validate(); err != nil {
+		return opts, err
+	}
+	if opts.Interval <= 0 {
+		return opts, fmt.Errorf("interval must be positive, got %v", opts.Interval)
+	}
+	if _, err := opts.Logger(output); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+// Logger builds the logger for the configured level and format
+func (o Options) Logger(w io.Writer) (*slog.Logger, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(o.LogLevel)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", o.LogLevel)
+	}
+	handlerOpts := &slog.HandlerOptions{Level: level}
+
+	switch strings.ToLower(o.LogFormat) {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, handlerOpts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, handlerOpts)), nil
+	}
+	return nil, fmt.Errorf("invalid log format %q", o.LogFormat)
+}
+
+// envOr returns a string Env Var, or def when it is unset
+func envOr(ev, def string) string {
+	if value := FillEnvVar(ev); value != "ENOENT" {
+		return value
+	}
+	return def
+}
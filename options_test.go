@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestParseOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		args    []string
+		want    Options
+		wantErr bool
+	}{
+		{
+			name: "Defaults",
+			want: Options{Addr: ":8899", Interval: time.Second, LogLevel: "info", LogFormat: "text", ShutdownTimeout: defShutdownTimeout},
+		},
+		{
+			name: "Env Vars",
+			env:  map[string]string{"TOAD_ADDR": ":9000", "TOAD_INTERVAL": "250ms", "TOAD_LOG_LEVEL": "debug", "TOAD_LOG_FORMAT": "json", "TOAD_CONFIG": "toad.json"},
+			want: Options{Addr: ":9000", Interval: 250 * time.Millisecond, LogLevel: "debug", LogFormat: "json", Config: "toad.json", ShutdownTimeout: defShutdownTimeout},
+		},
+		{
+			name: "Flags override Env Vars",
+			env:  map[string]string{"TOAD_ADDR": ":9000", "TOAD_LOG_LEVEL": "debug"},
+			args: []string{"-addr", "127.0.0.1:9100", "-interval", "5s", "-log-level", "warn", "-shutdown-timeout", "1m"},
+			want: Options{Addr: "127.0.0.1:9100", Interval: 5 * time.Second, LogLevel: "warn", LogFormat: "text", ShutdownTimeout: time.Minute},
+		},
+		{
+			name: "Control auth",
+			env:  map[string]string{"TOAD_CONTROL_TOKEN": "s3cret", "TOAD_CONTROL_PASSWORD": "hunter2"},
+			args: []string{"-control-user", "admin"},
+			want: Options{Addr: ":8899", Interval: time.Second, LogLevel: "info", LogFormat: "text", ShutdownTimeout: defShutdownTimeout,
+				Auth: ControlAuth{Token: "s3cret", User: "admin", Password: "hunter2"}},
+		},
+		{name: "Control user without a password", args: []string{"-control-user", "admin"}, wantErr: true},
+		{
+			name: "TLS",
+			env:  map[string]string{"TOAD_TLS_SELF_SIGNED": "true", "TOAD_TLS_HOSTS": "toad.local"},
+			args: []string{"-tls-client-ca", "ca.pem", "-tls-fault", "wrong-host"},
+			want: Options{Addr: ":8899", Interval: time.Second, LogLevel: "info", LogFormat: "text", ShutdownTimeout: defShutdownTimeout,
+				TLS: TLSOptions{SelfSigned: true, Hosts: "toad.local", ClientCA: "ca.pem", Fault: "wrong-host"}},
+		},
+		{name: "TLS cert without a key", args: []string{"-tls-cert", "cert.pem"}, wantErr: true},
+		{name: "Zero interval", args: []string{"-interval", "0s"}, wantErr: true},
+		{name: "Unknown log level", args: []string{"-log-level", "loud"}, wantErr: true},
+		{name: "Unknown log format", args: []string{"-log-format", "xml"}, wantErr: true},
+		{name: "Unknown flag", args: []string{"-port", "80"}, wantErr: true},
+		{name: "Extra arguments", args: []string{"config.json"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, ev := range []string{"TOAD_ADDR", "TOAD_INTERVAL", "TOAD_LOG_LEVEL", "TOAD_LOG_FORMAT", "TOAD_CONFIG", "TOAD_TARGETS", "TOAD_SHUTDOWN_TIMEOUT", "TOAD_CONTROL_TOKEN", "TOAD_CONTROL_USER", "TOAD_CONTROL_PASSWORD", "TOAD_TLS_CERT", "TOAD_TLS_KEY", "TOAD_TLS_SELF_SIGNED", "TOAD_TLS_HOSTS", "TOAD_TLS_CLIENT_CA", "TOAD_TLS_FAULT"} {
+				t.Setenv(ev, tt.env[ev])
+			}
+
+			got, err := ParseOptions(tt.args, io.Discard)
+			if tt.wantErr {
+				assertGotError(t, err)
+				return
+			}
+			assertError(t, err, nil)
+			if got != tt.want {
+				t.Errorf("Expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+
+	t.Run("Help", func(t *testing.T) {
+		var out bytes.Buffer
+		_, err := ParseOptions([]string{"-h"}, &out)
+		if !errors.Is(err, flag.ErrHelp) {
+			t.Errorf("Expected flag.ErrHelp, got %v", err)
+		}
+		assertStringContains(t, out.String(), "TOAD_INTERVAL")
+	})
+}
+
+func TestOptions_Logger(t *testing.T) {
+	var out bytes.Buffer
+	logger, err := Options{LogLevel: "warn", LogFormat: "json"}.Logger(&out)
+	assertError(t, err, nil)
+
+	logger.Info("hidden")
+	logger.Warn("shown")
+	if bytes.Contains(out.Bytes(), []byte("hidden")) {
+		t.Errorf("Expected info to be filtered at warn, got %s", out.String())
+	}
+	assertStringContains(t, out.String(), `"msg":"shown"`)
+}
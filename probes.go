@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+const healthTicks = 3 // Ticks without advancing before the handle is unhealthy
+
+// Build information, set by goreleaser with -ldflags "-X main.version=..."
+var (
+	version = "dev"
+	commit  = "none"
+	date    = "unknown"
+)
+
+// BuildInfo is served by /version
+type BuildInfo struct {
+	Version string `json:"version"`
+	Commit  string `json:"commit"`
+	Date    string `json:"date"`
+	Go      string `json:"go"`
+}
+
+// Build returns the injected build information,
+// falling back to what the Go toolchain recorded, e.g. for go install
+func Build() BuildInfo {
+	info := BuildInfo{Version: version, Commit: commit, Date: date, Go: runtime.Version()}
+
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	if info.Version == "dev" && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+		info.Version = bi.Main.Version
+	}
+	for _, s := range bi.Settings {
+		switch {
+		case s.Key == "vcs.revision" && info.Commit == "none":
+			info.Commit = s.Value
+		case s.Key == "vcs.time" && info.Date == "unknown":
+			info.Date = s.Value
+		}
+	}
+	return info
+}
+
+// HealthHandler fails when the tick loop has stopped advancing, unless it was paused
+func (eph *EPHandle) HealthHandler(w http.ResponseWriter, r *http.Request) {
+	since := time.Since(time.Unix(0, eph.LastTick.Load()))
+	if since > healthTicks*TickInterval && !eph.Paused.Load() {
+		slog.Error("Tick loop stalled", slog.Duration("since", since))
+		http.Error(w, fmt.Sprintf("tick loop stalled, last tick %s ago", since.Round(time.Millisecond)), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
+
+// ReadyHandler fails until the handle is serving, and again while shutting down
+func (eph *EPHandle) ReadyHandler(w http.ResponseWriter, r *http.Request) {
+	if !eph.Ready.Load() {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ready\n"))
+}
+
+// VersionHandler returns the build information
+func (eph *EPHandle) VersionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Build())
+}
+
+// SetReady marks the handle and its mounted targets ready or not
+func (eph *EPHandle) SetReady(ready bool) {
+	eph.Ready.Store(ready)
+	for _, target := range eph.Mounts {
+		target.SetReady(ready)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestEPHandle_Probes(t *testing.T) {
+	eph := NewEPHandle([]string{"exp", "float", "int"}, []string{"up", "down"})
+	defer eph.Ticker.Stop()
+	mux := eph.SetupMux()
+
+	get := func(target string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", target, nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("Healthy after start", func(t *testing.T) {
+		w := get("/healthz")
+		assertStatus(t, w.Code, http.StatusOK)
+	})
+
+	t.Run("Unhealthy when ticks stall", func(t *testing.T) {
+		eph.LastTick.Store(time.Now().Add(-healthTicks * TickInterval * 2).UnixNano())
+		w := get("/healthz")
+		assertStatus(t, w.Code, http.StatusServiceUnavailable)
+		assertStringContains(t, w.Body.String(), "stalled")
+
+		eph.Advance()
+		assertStatus(t, get("/healthz").Code, http.StatusOK)
+	})
+
+	t.Run("Ready only while serving", func(t *testing.T) {
+		target := NewTargetEPHandle([]string{"int"}, []string{"up"}, NewEnv(nil), nil)
+		eph.Mount("/t", target)
+
+		assertStatus(t, get("/readyz").Code, http.StatusServiceUnavailable)
+		eph.SetReady(true)
+		assertStatus(t, get("/readyz").Code, http.StatusOK)
+		if !target.Ready.Load() {
+			t.Error("Expected the mounted target to be ready")
+		}
+		eph.SetReady(false)
+		assertStatus(t, get("/readyz").Code, http.StatusServiceUnavailable)
+	})
+
+	t.Run("Version", func(t *testing.T) {
+		w := get("/version")
+		assertStatus(t, w.Code, http.StatusOK)
+
+		var info BuildInfo
+		assertError(t, json.NewDecoder(w.Body).Decode(&info), nil)
+		if info.Version == "" || info.Go == "" {
+			t.Errorf("Expected a version and Go version, got %+v", info)
+		}
+	})
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxReplayTicks = 1000000 // Upper bound of a resampled capture
+
+// capturePoint is one value from a recorded metric
+type capturePoint struct {
+	Time  time.Time
+	Value float64
+}
+
+// NewReplayCycBuffer creates a buffer that plays back a captured metric.
+// The capture is resampled to one value per tick, where every tick covers
+// TickInterval * speed of capture time, so a speed of 2 plays twice as fast.
+// When once is set the buffer stops on its final value instead of looping.
+func NewReplayCycBuffer(path string, speed float64, once bool, f string, tail int) (*CycBuffer, error) {
+	if speed <= 0 {
+		return nil, fmt.Errorf("replay speed must be positive, got %v", speed)
+	}
+
+	points, err := readCapture(path)
+	if err != nil {
+		return nil, err
+	}
+
+	span := points[len(points)-1].Time.Sub(points[0].Time)
+	step := time.Duration(float64(TickInterval) * speed)
+	if step <= 0 {
+		return nil, fmt.Errorf("replay speed %v is too small", speed)
+	}
+	ticks := int(span/step) + 1
+	if ticks > maxReplayTicks {
+		return nil, fmt.Errorf("replay of %s needs %d ticks, more than %d", path, ticks, maxReplayTicks)
+	}
+
+	// Each tick takes the latest captured value at or before its time
+	values := make([]string, 0, ticks)
+	p := 0
+	for i := 0; i < ticks; i++ {
+		at := points[0].Time.Add(time.Duration(i) * step)
+		for p+1 < len(points) && !points[p+1].Time.After(at) {
+			p++
+		}
+		values = append(values, FormatValue(points[p].Value, f, tail))
+	}
+
+	return &CycBuffer{
+		NType:   f,
+		MAlgo:   "replay",
+		Values:  values,
+		MaxSize: len(values),
+		Index:   0,
+		Once:    once,
+	}, nil
+}
+
+// readCapture reads a capture ordered by time.
+// Files ending in .json are a Prometheus query_range response,
+// or an array of [timestamp, "value"] pairs.
+// Anything else is CSV of timestamp,value with an optional header.
+// Timestamps are Unix seconds or RFC3339.
+func readCapture(path string) ([]capturePoint, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open capture: %w", err)
+	}
+	defer file.Close()
+
+	var points []capturePoint
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		points, err = readCaptureJSON(file)
+	} else {
+		points, err = readCaptureCSV(file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read capture %s: %w", path, err)
+	}
+	if len(points) == 0 {
+		return nil, fmt.Errorf("capture %s has no values", path)
+	}
+
+	sort.SliceStable(points, func(i, j int) bool {
+		return points[i].Time.Before(points[j].Time)
+	})
+	return points, nil
+}
+
+func readCaptureCSV(r io.Reader) ([]capturePoint, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+
+	var points []capturePoint
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		point, err := parseCapturePoint(record[0], record[1])
+		if err != nil {
+			if line == 1 {
+				continue // Header
+			}
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		points = append(points, point)
+	}
+	return points, nil
+}
+
+func readCaptureJSON(r io.Reader) ([]capturePoint, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var pairs [][2]any
+	if err := json.Unmarshal(data, &pairs); err != nil {
+		// Not bare pairs, try a query_range response
+		var resp struct {
+			Data struct {
+				Result []struct {
+					Values [][2]any `json:"values"`
+				} `json:"result"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(data, &resp); err != nil {
+			return nil, err
+		}
+		if len(resp.Data.Result) == 0 {
+			return nil, fmt.Errorf("query result is empty")
+		}
+		pairs = resp.Data.Result[0].Values
+	}
+
+	points := make([]capturePoint, 0, len(pairs))
+	for i, pair := range pairs {
+		point, err := parseCapturePoint(fmt.Sprint(pair[0]), fmt.Sprint(pair[1]))
+		if err != nil {
+			return nil, fmt.Errorf("value %d: %w", i, err)
+		}
+		points = append(points, point)
+	}
+	return points, nil
+}
+
+func parseCapturePoint(ts, value string) (capturePoint, error) {
+	t, err := parseQueryTime(strings.TrimSpace(ts))
+	if err != nil {
+		return capturePoint{}, fmt.Errorf("invalid timestamp %q", ts)
+	}
+
+	v, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return capturePoint{}, fmt.Errorf("invalid value %q", value)
+	}
+	return capturePoint{Time: t, Value: v}, nil
+}
+
+// Replay buffer for a numeric type, configured by Env Vars
+func (eph *EPHandle) getReplayBuffer(mt string) (*CycBuffer, error) {
+	path := eph.Env.Get(strings.ToUpper(mt) + "_REPLAY")
+	tail := eph.Env.Int(strings.ToUpper(mt)+"_TAIL", defTail)
+	once := eph.Env.Int(strings.ToUpper(mt)+"_ONCE", 0) == 1
+	speedenv := eph.Env.Get(strings.ToUpper(mt) + "_SPEED")
+	speed, err := strconv.ParseFloat(speedenv, 64)
+	if err != nil {
+		speed = defSpeed
+	}
+
+	return NewReplayCycBuffer(path, speed, once, mt, tail)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// Write a capture file into a temporary directory
+func writeCapture(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(path, []byte(content), 0644)
+	assertError(t, err, nil)
+	return path
+}
+
+func TestNewReplayCycBuffer(t *testing.T) {
+	csvCapture := "timestamp,value\n1000,1\n1002,2\n1004,3\n"
+	jsonPairs := `[[1000, "1"], [1002, "2"], [1004, "3"]]`
+	jsonQuery := `{"status":"success","data":{"resultType":"matrix","result":[{"metric":{"__name__":"up"},"values":[[1000,"1"],[1002,"2"],[1004,"3"]]}]}}`
+	rfcCapture := "2026-01-01T00:00:00Z,1\n2026-01-01T00:00:02Z,2\n2026-01-01T00:00:04Z,3\n"
+
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		speed   float64
+		format  string
+		want    []string
+	}{
+		{name: "CSV at original speed", file: "c.csv", content: csvCapture, speed: 1, format: "int", want: []string{"1", "1", "2", "2", "3"}},
+		{name: "CSV at double speed", file: "c.csv", content: csvCapture, speed: 2, format: "int", want: []string{"1", "2", "3"}},
+		{name: "CSV at half speed", file: "c.csv", content: csvCapture, speed: 0.5, format: "int", want: []string{"1", "1", "1", "1", "2", "2", "2", "2", "3"}},
+		{name: "RFC3339 timestamps", file: "c.csv", content: rfcCapture, speed: 2, format: "float", want: []string{"1.0", "2.0", "3.0"}},
+		{name: "JSON pairs", file: "c.json", content: jsonPairs, speed: 2, format: "int", want: []string{"1", "2", "3"}},
+		{name: "JSON query response", file: "c.json", content: jsonQuery, speed: 2, format: "exp", want: []string{"1.0e+00", "2.0e+00", "3.0e+00"}},
+		{name: "Unordered with special values", file: "c.csv", content: "1002,+Inf\n1000,NaN\n", speed: 2, format: "float", want: []string{"NaN", "+Inf"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeCapture(t, tt.file, tt.content)
+			buffer, err := NewReplayCycBuffer(path, tt.speed, false, tt.format, 1)
+			assertError(t, err, nil)
+			if !slices.Equal(buffer.Values, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, buffer.Values)
+			}
+			assertInt(t, buffer.MaxSize, len(tt.want))
+		})
+	}
+
+	errs := []struct {
+		name    string
+		file    string
+		content string
+		speed   float64
+	}{
+		{name: "Missing file", file: "", speed: 1},
+		{name: "Empty capture", file: "c.csv", content: "timestamp,value\n", speed: 1},
+		{name: "Bad value", file: "c.csv", content: "1000,1\n1001,one\n", speed: 1},
+		{name: "Bad JSON", file: "c.json", content: `{"data":`, speed: 1},
+		{name: "Zero speed", file: "c.csv", content: csvCapture, speed: 0},
+		{name: "Too many ticks", file: "c.csv", content: "0,1\n100000000,2\n", speed: 1},
+	}
+
+	for _, tt := range errs {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "missing.csv")
+			if tt.file != "" {
+				path = writeCapture(t, tt.file, tt.content)
+			}
+			_, err := NewReplayCycBuffer(path, tt.speed, false, "int", 1)
+			assertGotError(t, err)
+		})
+	}
+}
+
+func TestCycBuffer_ShiftOnce(t *testing.T) {
+	path := writeCapture(t, "c.csv", "1000,1\n1001,2\n1002,3\n")
+	buffer, err := NewReplayCycBuffer(path, 1, true, "int", 1)
+	assertError(t, err, nil)
+
+	var got []string
+	for i := 0; i < 5; i++ {
+		got = append(got, buffer.Shift())
+	}
+	want := []string{"2", "3", "3", "3", "3"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestEPHandle_Replay(t *testing.T) {
+	path := writeCapture(t, "c.csv", "1000,7\n1001,8\n")
+	t.Setenv("INT_REPLAY", path)
+
+	eph := NewEPHandle([]string{"exp", "float", "int"}, []string{"up", "down"})
+	defer eph.Ticker.Stop()
+
+	err := eph.AddMetric(SeriesConfig{Name: "captured", Type: "float", Algo: "replay", File: path, Tail: 2})
+	assertError(t, err, nil)
+
+	t.Run("Replay metrics need a file", func(t *testing.T) {
+		err := eph.AddMetric(SeriesConfig{Name: "nofile", Type: "int", Algo: "replay"})
+		assertGotError(t, err)
+	})
+
+	mux := eph.SetupMux()
+	tests := []struct {
+		name   string
+		target string
+		expect string
+	}{
+		{name: "Built-in replay series", target: "/series/int/replay", expect: "Metric_int_replay: 7"},
+		{name: "User-defined replay metric", target: "/series/captured", expect: "captured: 7.00"},
+		{name: "Metrics page", target: "/metrics", expect: "Metric_int_replay: 7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.target, nil)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
+			assertStatus(t, w.Code, http.StatusOK)
+			assertStringContains(t, w.Body.String(), tt.expect)
+		})
+	}
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const maxSeriesBody = 1 << 20 // Largest series config accepted
+
+// SeriesInfo is a series as listed by the series API
+type SeriesInfo struct {
+	ID       string       `json:"id"`
+	Builtin  bool         `json:"builtin"`            // Configured by Env Vars, e.g. Metric_int_up
+	Template string       `json:"template,omitempty"` // Template that generated the series
+	Group    string       `json:"group,omitempty"`    // Correlated group the series belongs to
+	Config   SeriesConfig `json:"config"`
+
+	Derived *DerivedConfig `json:"derived,omitempty"` // Expression of a derived metric
+	Machine *StateConfig   `json:"machine,omitempty"` // States of a state metric, served with its _state series
+
+	State SeriesState `json:"state"`
+}
+
+// SeriesState is the position of a series in its buffer
+type SeriesState struct {
+	Index  int      `json:"index"`
+	Value  string   `json:"value"`
+	Values []string `json:"values"`
+}
+
+// state copies the buffer position under its lock
+func (cb *CycBuffer) state() SeriesState {
+	cb.MU.Lock()
+	defer cb.MU.Unlock()
+	return SeriesState{
+		Index:  cb.Index,
+		Value:  cb.served(),
+		Values: slices.Clone(cb.Values),
+	}
+}
+
+// lookupSeries finds a built-in buffer, with its type, or a user-defined metric by ID.
+// Callers must hold MetricsMU.
+func (eph *EPHandle) lookupSeries(id string) (*MType, *CycBuffer, *Metric) {
+	if m, ok := eph.Metrics[id]; ok {
+		return nil, nil, m
+	}
+	for _, mt := range eph.MTypes {
+		for algo, buff := range mt.ShiftRegisters {
+			if builtinName(mt.Name, algo) == id {
+				return mt, buff, nil
+			}
+		}
+	}
+	return nil, nil, nil
+}
+
+// lookupComputed finds a derived metric, or the state machine serving a numeric or _state series, by ID.
+// Callers must hold MetricsMU.
+func (eph *EPHandle) lookupComputed(id string) (*Derived, *StateMachine) {
+	for _, d := range eph.Derived {
+		if d.ID == id {
+			return d, nil
+		}
+	}
+	for _, sm := range eph.States {
+		if sm.ID == id || slices.Contains(sm.OneHot, id) {
+			return nil, sm
+		}
+	}
+	return nil, nil
+}
+
+// builtinInfo describes a built-in series with the settings it was last built from.
+// Callers must hold MetricsMU.
+func (eph *EPHandle) builtinInfo(mt *MType, buff *CycBuffer) SeriesInfo {
+	sc, ok := mt.Overrides[buff.MAlgo]
+	if !ok && buff.MAlgo == "replay" {
+		sc = SeriesConfig{Name: builtinName(mt.Name, buff.MAlgo), Type: mt.Name, Algo: buff.MAlgo, File: eph.Env.Get(strings.ToUpper(mt.Name) + "_REPLAY")}
+	} else if !ok {
+		sc = eph.builtinConfig(mt.Name, buff.MAlgo)
+	}
+	return SeriesInfo{ID: sc.Name, Builtin: true, Config: sc, State: buff.state()}
+}
+
+func metricInfo(m *Metric) SeriesInfo {
+	return SeriesInfo{ID: m.ID, Template: m.Template, Group: m.Group, Config: m.Config, State: m.Buffer.state()}
+}
+
+// derivedInfo describes a derived metric with the value it was last served
+func derivedInfo(d *Derived, snap *Snapshot) SeriesInfo {
+	sample, _ := snap.Lookup(d.ID)
+	return SeriesInfo{
+		ID:      d.ID,
+		Config:  SeriesConfig{Name: d.Config.Name, Labels: d.Config.Labels, Type: d.Config.Type, Algo: "derived", Tail: d.Config.Tail},
+		Derived: &d.Config,
+		State:   SeriesState{Value: sample.Value},
+	}
+}
+
+// machineInfo describes a state machine, its index is the current state
+func machineInfo(sm *StateMachine) SeriesInfo {
+	sm.MU.Lock()
+	current := sm.Current
+	sm.MU.Unlock()
+
+	values := make([]string, len(sm.Config.States))
+	for i := range values {
+		values[i] = strconv.Itoa(i)
+		if sm.Config.Values != nil {
+			values[i] = strconv.FormatFloat(sm.Config.Values[i], 'f', -1, 64)
+		}
+	}
+	return SeriesInfo{
+		ID:      sm.ID,
+		Config:  SeriesConfig{Name: sm.Config.Name, Labels: sm.Config.Labels, Type: "int", Algo: "markov"},
+		Machine: &sm.Config,
+		State:   SeriesState{Index: current, Value: values[current], Values: values},
+	}
+}
+
+// SeriesListHandler returns every built-in, user-defined, state and derived series,
+// ordered by ID within each kind, the same order as /metrics.
+// A state machine is listed once under its numeric series.
+func (eph *EPHandle) SeriesListHandler(w http.ResponseWriter, r *http.Request) {
+	snap := eph.Snapshot()
+
+	eph.MetricsMU.RLock()
+	var infos []SeriesInfo
+	for _, mt := range eph.MTypes {
+		for _, buff := range mt.ShiftRegisters {
+			infos = append(infos, eph.builtinInfo(mt, buff))
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ID < infos[j].ID
+	})
+	for _, m := range eph.sortedMetrics() {
+		infos = append(infos, metricInfo(m))
+	}
+	for _, sm := range eph.States {
+		infos = append(infos, machineInfo(sm))
+	}
+	for _, d := range eph.Derived {
+		infos = append(infos, derivedInfo(d, snap))
+	}
+	eph.MetricsMU.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(infos)
+}
+
+// SeriesGetHandler returns one series, its ID is URL-escaped in the path
+func (eph *EPHandle) SeriesGetHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	snap := eph.Snapshot()
+
+	eph.MetricsMU.RLock()
+	mt, buff, m := eph.lookupSeries(id)
+	d, sm := eph.lookupComputed(id)
+	var info SeriesInfo
+	switch {
+	case m != nil:
+		info = metricInfo(m)
+	case buff != nil:
+		info = eph.builtinInfo(mt, buff)
+	case d != nil:
+		info = derivedInfo(d, snap)
+	case sm != nil:
+		info = machineInfo(sm)
+	}
+	eph.MetricsMU.RUnlock()
+
+	if info.ID == "" {
+		slog.Error("Invalid series: " + id)
+		http.Error(w, "Invalid series: "+id, http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+// SeriesCreateHandler adds a user-defined metric from a JSON series config
+func (eph *EPHandle) SeriesCreateHandler(w http.ResponseWriter, r *http.Request) {
+	var sc SeriesConfig
+	body, err := readSeriesBody(w, r)
+	if err == nil {
+		err = decodeSeriesConfig(body, &sc)
+	}
+	if err != nil {
+		slog.Error("Invalid series config", slog.Any("error", err))
+		http.Error(w, "Invalid series config: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err = eph.AddMetric(sc); err != nil {
+		code := http.StatusBadRequest
+		if errors.Is(err, ErrDuplicateMetric) {
+			code = http.StatusConflict
+		}
+		slog.Error("Could not create series", slog.Any("error", err))
+		http.Error(w, "Could not create series: "+err.Error(), code)
+		return
+	}
+
+	id := SeriesID(sc.Name, sc.Labels)
+	info := SeriesInfo{ID: id, Config: sc}
+	eph.MetricsMU.RLock()
+	if m, ok := eph.Metrics[id]; ok { // Unless a concurrent request removed it
+		info = metricInfo(m)
+	}
+	eph.MetricsMU.RUnlock()
+
+	slog.Info("Series created",
+		slog.String("method", r.Method),
+		slog.String("request", r.RequestURI),
+		slog.String("remote_addr", r.RemoteAddr),
+		slog.String("series", id))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/api/v1/series/"+url.PathEscape(id))
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(info)
+}
+
+// SeriesPatchHandler changes the config of a series and generates new values.
+// Fields left out of the body keep their current setting.
+// Built-in series only change size, limit, tail, mod, specials and faults, until the next reset of their type.
+// Derived, state and group metrics cannot be changed, only deleted.
+func (eph *EPHandle) SeriesPatchHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	// Read before locking, so a slow client does not hold up ticks
+	body, err := readSeriesBody(w, r)
+	if err != nil {
+		slog.Error("Invalid series config", slog.Any("error", err))
+		http.Error(w, "Invalid series config: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	info, code, err := eph.patchSeries(id, body)
+	if err != nil {
+		slog.Error("Could not change series", slog.String("series", id), slog.Any("error", err))
+		http.Error(w, "Could not change series: "+err.Error(), code)
+		return
+	}
+
+	// Serve the new values without waiting for the next tick
+	eph.Publish()
+
+	slog.Info("Series changed",
+		slog.String("method", r.Method),
+		slog.String("request", r.RequestURI),
+		slog.String("remote_addr", r.RemoteAddr),
+		slog.String("series", id),
+		slog.String("new_values", strings.Join(info.State.Values, ", ")))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+// patchSeries changes a series, building its new values outside MetricsMU
+// so a generator that fails cannot leave ticks blocked.
+// It returns the status code for the error, if any.
+func (eph *EPHandle) patchSeries(id string, body []byte) (SeriesInfo, int, error) {
+	eph.MetricsMU.RLock()
+	mt, buff, m := eph.lookupSeries(id)
+	d, sm := eph.lookupComputed(id)
+	var current SeriesInfo
+	if buff != nil {
+		current = eph.builtinInfo(mt, buff)
+	}
+	eph.MetricsMU.RUnlock()
+
+	if d != nil || sm != nil {
+		return SeriesInfo{}, http.StatusBadRequest, fmt.Errorf("derived and state series %s cannot be changed, only deleted", id)
+	}
+
+	switch {
+	case m != nil && m.Group != "":
+		// Members only hold their correlation with the driver as generated together
+		return SeriesInfo{}, http.StatusBadRequest, fmt.Errorf("series %s of group %s cannot be changed, only deleted", id, m.Group)
+	case m != nil:
+		metric, err := eph.patchMetric(body, m)
+		if err != nil {
+			return SeriesInfo{}, http.StatusBadRequest, err
+		}
+
+		eph.MetricsMU.Lock()
+		defer eph.MetricsMU.Unlock()
+		if eph.Metrics[id] != m {
+			return SeriesInfo{}, http.StatusConflict, fmt.Errorf("series %s changed during the request", id)
+		}
+		eph.Metrics[id] = metric
+		return metricInfo(metric), http.StatusOK, nil
+	case buff != nil:
+		sc, err := patchBuiltin(body, current.Config)
+		if err != nil {
+			return SeriesInfo{}, http.StatusBadRequest, err
+		}
+		newBuff := eph.newBuiltinBuffer(sc)
+
+		eph.MetricsMU.Lock()
+		defer eph.MetricsMU.Unlock()
+		if mt.ShiftRegisters[buff.MAlgo] != buff {
+			return SeriesInfo{}, http.StatusConflict, fmt.Errorf("series %s changed during the request", id)
+		}
+		buff.Replace(newBuff)
+		mt.Overrides[buff.MAlgo] = sc
+		return eph.builtinInfo(mt, buff), http.StatusOK, nil
+	default:
+		return SeriesInfo{}, http.StatusNotFound, fmt.Errorf("invalid series: %s", id)
+	}
+}
+
+// patchMetric builds a user-defined metric from the changed config, keeping its history
+func (eph *EPHandle) patchMetric(body []byte, m *Metric) (*Metric, error) {
+	// The body is decoded over a copy, so a bad request leaves the metric alone
+	sc := m.Config
+	sc.Labels = maps.Clone(sc.Labels)
+	sc.Params = maps.Clone(sc.Params)
+	sc.Buckets = slices.Clone(sc.Buckets)
+	sc.Levels = slices.Clone(sc.Levels)
+	if err := decodeSeriesConfig(body, &sc); err != nil {
+		return nil, err
+	}
+	if sc.Name != m.Config.Name || !maps.Equal(sc.Labels, m.Config.Labels) {
+		return nil, fmt.Errorf("name and labels of %s cannot change", m.ID)
+	}
+
+	sc.rand = eph.Rand
+	sc.history = eph.HistorySize
+	metric, err := NewMetric(sc)
+	if err != nil {
+		return nil, err
+	}
+	metric.Template = m.Template
+	metric.Buffer.History = m.Buffer.History
+	return metric, nil
+}
+
+// patchBuiltin reads the changed settings of a built-in series over its current config
+func patchBuiltin(body []byte, current SeriesConfig) (SeriesConfig, error) {
+	if current.Algo == "replay" {
+		return SeriesConfig{}, fmt.Errorf("replayed series %s cannot be changed", current.Name)
+	}
+
+	sc := current
+	if err := decodeSeriesConfig(body, &sc); err != nil {
+		return SeriesConfig{}, err
+	}
+	changed := sc
+	changed.Size, changed.Limit, changed.Tail, changed.Mod, changed.Specials, changed.Faults = current.Size, current.Limit, current.Tail, current.Mod, current.Specials, current.Faults
+	if changed.Name != current.Name || changed.Type != current.Type || changed.Algo != current.Algo ||
+		len(changed.Labels) > 0 || len(changed.Params) > 0 || len(changed.Buckets) > 0 || len(changed.Levels) > 0 ||
+		changed.File != "" || changed.Speed != 0 || changed.Once {
+		return SeriesConfig{}, fmt.Errorf("built-in series %s only changes size, limit, tail, mod, specials and faults", current.Name)
+	}
+	if err := sc.Faults.Validate(); err != nil {
+		return SeriesConfig{}, fmt.Errorf("invalid faults for %s: %w", current.Name, err)
+	}
+	if sc.Size < 0 || sc.Limit < 0 || sc.Tail < 0 {
+		return SeriesConfig{}, fmt.Errorf("size, limit and tail of %s cannot be negative", current.Name)
+	}
+	if sc.Limit > math.MaxInt32 {
+		return SeriesConfig{}, fmt.Errorf("limit of %s cannot be over %d", current.Name, math.MaxInt32)
+	}
+	sc.applyDefaults()
+	return sc, nil
+}
+
+// SeriesDeleteHandler stops serving a series.
+// Deleting a state metric, or any of its _state series, removes all of them.
+func (eph *EPHandle) SeriesDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	eph.MetricsMU.Lock()
+	mt, buff, m := eph.lookupSeries(id)
+	d, sm := eph.lookupComputed(id)
+	switch {
+	case m != nil:
+		delete(eph.Metrics, id)
+		if m.Template != "" {
+			eph.dropEmptyTemplate(m.Template)
+		}
+	case buff != nil:
+		delete(mt.ShiftRegisters, buff.MAlgo)
+		delete(mt.Overrides, buff.MAlgo)
+	case d != nil:
+		eph.Derived = slices.DeleteFunc(eph.Derived, func(other *Derived) bool { return other == d })
+	case sm != nil:
+		eph.States = slices.DeleteFunc(eph.States, func(other *StateMachine) bool { return other == sm })
+	}
+	eph.MetricsMU.Unlock()
+
+	if m == nil && buff == nil && d == nil && sm == nil {
+		slog.Error("Invalid series: " + id)
+		http.Error(w, "Invalid series: "+id, http.StatusNotFound)
+		return
+	}
+	eph.Publish()
+
+	slog.Info("Series deleted",
+		slog.String("method", r.Method),
+		slog.String("request", r.RequestURI),
+		slog.String("remote_addr", r.RemoteAddr),
+		slog.String("series", id))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// readSeriesBody reads a request body up to maxSeriesBody
+func readSeriesBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	return io.ReadAll(http.MaxBytesReader(w, r.Body, maxSeriesBody))
+}
+
+// decodeSeriesConfig reads a JSON series config over sc, rejecting unknown fields
+func decodeSeriesConfig(body []byte, sc *SeriesConfig) error {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	return dec.Decode(sc)
+}
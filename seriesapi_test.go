@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serve sends a request with an optional JSON body through the mux
+func serve(t *testing.T, mux http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	return w
+}
+
+func seriesPath(id string) string {
+	return "/api/v1/series/" + url.PathEscape(id)
+}
+
+func TestEPHandle_SeriesAPI(t *testing.T) {
+	for _, ev := range []string{"INT_SIZE", "INT_LIMIT", "INT_TAIL", "INT_MOD"} {
+		t.Setenv(ev, "")
+	}
+	eph := NewEPHandle([]string{"exp", "float", "int"}, []string{"up", "down"})
+	defer eph.Ticker.Stop()
+	mux := eph.SetupMux()
+
+	const id = `http_requests{path="/api/v1"}`
+	created := `{"name": "http_requests", "labels": {"path": "/api/v1"}, "type": "int", "algo": "poisson", "size": 5, "params": {"lambda": 3}}`
+
+	t.Run("Creates a series", func(t *testing.T) {
+		w := serve(t, mux, "POST", "/api/v1/series", created)
+		assertStatus(t, w.Code, http.StatusCreated)
+		if w.Header().Get("Location") != seriesPath(id) {
+			t.Errorf("Expected location %s, got %s", seriesPath(id), w.Header().Get("Location"))
+		}
+
+		var info SeriesInfo
+		assertError(t, json.NewDecoder(w.Body).Decode(&info), nil)
+		if info.ID != id || info.Builtin {
+			t.Errorf("Expected user-defined series %s, got %+v", id, info)
+		}
+		assertInt(t, len(info.State.Values), 5)
+		if _, ok := eph.Snapshot().Lookup(id); !ok {
+			t.Errorf("Expected %s to be served", id)
+		}
+	})
+
+	createErrors := []struct {
+		name     string
+		body     string
+		wantCode int
+	}{
+		{name: "Duplicate", body: created, wantCode: http.StatusConflict},
+		{name: "Duplicate of a built-in series", body: `{"name": "Metric_int_up", "type": "int", "algo": "up"}`, wantCode: http.StatusConflict},
+		{name: "Invalid algo", body: `{"name": "toad", "type": "int", "algo": "sideways"}`, wantCode: http.StatusBadRequest},
+		{name: "Negative limit", body: `{"name": "toad", "type": "int", "algo": "up", "limit": -5}`, wantCode: http.StatusBadRequest},
+		{name: "Negative size", body: `{"name": "toad", "type": "int", "algo": "up", "size": -1}`, wantCode: http.StatusBadRequest},
+		{name: "Unknown field", body: `{"name": "toad", "type": "int", "algo": "up", "colour": "green"}`, wantCode: http.StatusBadRequest},
+		{name: "Not JSON", body: `toad`, wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range createErrors {
+		t.Run("Rejects "+tt.name, func(t *testing.T) {
+			w := serve(t, mux, "POST", "/api/v1/series", tt.body)
+			assertStatus(t, w.Code, tt.wantCode)
+		})
+	}
+
+	t.Run("Lists built-in series then user-defined series", func(t *testing.T) {
+		w := serve(t, mux, "GET", "/api/v1/series", "")
+		assertStatus(t, w.Code, http.StatusOK)
+
+		var infos []SeriesInfo
+		assertError(t, json.NewDecoder(w.Body).Decode(&infos), nil)
+		assertInt(t, len(infos), 7)
+		if infos[0].ID != "Metric_exp_down" || !infos[0].Builtin {
+			t.Errorf("Expected built-in Metric_exp_down first, got %+v", infos[0])
+		}
+		if infos[6].ID != id || infos[6].Config.Params["lambda"] != 3 {
+			t.Errorf("Expected %s last with its params, got %+v", id, infos[6])
+		}
+	})
+
+	t.Run("Gets a series by escaped ID", func(t *testing.T) {
+		w := serve(t, mux, "GET", seriesPath("Metric_int_up"), "")
+		assertStatus(t, w.Code, http.StatusOK)
+
+		var info SeriesInfo
+		assertError(t, json.NewDecoder(w.Body).Decode(&info), nil)
+		if info.Config.Size != defSize || info.Config.Limit != defLimit {
+			t.Errorf("Expected default size and limit, got %+v", info.Config)
+		}
+
+		w = serve(t, mux, "GET", seriesPath(id), "")
+		assertStatus(t, w.Code, http.StatusOK)
+		w = serve(t, mux, "GET", seriesPath("toad"), "")
+		assertStatus(t, w.Code, http.StatusNotFound)
+	})
+
+	patches := []struct {
+		name     string
+		id       string
+		body     string
+		wantCode int
+		wantSize int
+	}{
+		{name: "Changes params of a user-defined series", id: id, body: `{"size": 8, "params": {"lambda": 20}}`, wantCode: http.StatusOK, wantSize: 8},
+		{name: "Changes the algo of a user-defined series", id: id, body: `{"algo": "up", "params": null}`, wantCode: http.StatusOK, wantSize: 8},
+		{name: "Keeps labels of a user-defined series", id: id, body: `{"labels": {"path": "/"}}`, wantCode: http.StatusBadRequest},
+		{name: "Rejects invalid params", id: id, body: `{"params": {"lambda": 1}}`, wantCode: http.StatusBadRequest},
+		{name: "Changes the size of a built-in series", id: "Metric_int_up", body: `{"size": 3, "limit": 50}`, wantCode: http.StatusOK, wantSize: 3},
+		{name: "Keeps the algo of a built-in series", id: "Metric_int_up", body: `{"algo": "random"}`, wantCode: http.StatusBadRequest},
+		{name: "Rejects a negative size", id: "Metric_int_up", body: `{"size": -1}`, wantCode: http.StatusBadRequest},
+		{name: "Rejects a user-defined negative limit", id: id, body: `{"limit": -1}`, wantCode: http.StatusBadRequest},
+		{name: "Rejects a limit over int32", id: "Metric_int_up", body: `{"limit": 2147483648}`, wantCode: http.StatusBadRequest},
+		{name: "Rejects a user-defined limit over int32", id: id, body: `{"limit": 2147483648}`, wantCode: http.StatusBadRequest},
+		{name: "Unknown series", id: "toad", body: `{"size": 3}`, wantCode: http.StatusNotFound},
+	}
+	for _, tt := range patches {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(t, mux, "PATCH", seriesPath(tt.id), tt.body)
+			assertStatus(t, w.Code, tt.wantCode)
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+
+			var info SeriesInfo
+			assertError(t, json.NewDecoder(w.Body).Decode(&info), nil)
+			assertInt(t, len(info.State.Values), tt.wantSize)
+			sample, _ := eph.Snapshot().Lookup(tt.id)
+			assertInt(t, len(sample.Values), tt.wantSize)
+		})
+	}
+
+	t.Run("Ticks after rejected changes", func(t *testing.T) {
+		done := make(chan struct{})
+		go func() {
+			eph.Advance()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected ticks not to be blocked")
+		}
+	})
+
+	t.Run("Reset drops built-in changes", func(t *testing.T) {
+		w := serve(t, mux, "POST", "/reset/INT_SIZE/4", "")
+		assertStatus(t, w.Code, http.StatusOK)
+
+		var info SeriesInfo
+		w = serve(t, mux, "GET", seriesPath("Metric_int_up"), "")
+		assertError(t, json.NewDecoder(w.Body).Decode(&info), nil)
+		if info.Config.Size != 4 || info.Config.Limit != defLimit {
+			t.Errorf("Expected size 4 and the default limit, got %+v", info.Config)
+		}
+	})
+
+	t.Run("Deletes series", func(t *testing.T) {
+		for _, del := range []string{id, "Metric_int_up"} {
+			w := serve(t, mux, "DELETE", seriesPath(del), "")
+			assertStatus(t, w.Code, http.StatusNoContent)
+			if _, ok := eph.Snapshot().Lookup(del); ok {
+				t.Errorf("Expected %s not to be served", del)
+			}
+			w = serve(t, mux, "DELETE", seriesPath(del), "")
+			assertStatus(t, w.Code, http.StatusNotFound)
+		}
+		if _, ok := eph.MTypes["int"].ShiftRegisters["up"]; ok {
+			t.Error("Expected Metric_int_up to be removed from its type")
+		}
+
+		w := serve(t, mux, "GET", "/metrics", "")
+		if strings.Contains(w.Body.String(), "Metric_int_up") || strings.Contains(w.Body.String(), "http_requests") {
+			t.Errorf("Expected deleted series not to be scraped, got %s", w.Body.String())
+		}
+	})
+
+	t.Run("Needs control auth to change series", func(t *testing.T) {
+		eph.Auth = ControlAuth{Token: "s3cret"}
+		defer func() { eph.Auth = ControlAuth{} }()
+
+		assertStatus(t, serve(t, mux, "POST", "/api/v1/series", created).Code, http.StatusUnauthorized)
+		assertStatus(t, serve(t, mux, "PATCH", seriesPath("Metric_int_down"), `{"size": 3}`).Code, http.StatusUnauthorized)
+		assertStatus(t, serve(t, mux, "DELETE", seriesPath("Metric_int_down"), "").Code, http.StatusUnauthorized)
+		assertStatus(t, serve(t, mux, "GET", "/api/v1/series", "").Code, http.StatusOK)
+	})
+}
+
+func TestEPHandle_SeriesAPIComputed(t *testing.T) {
+	eph := NewEPHandle([]string{"exp", "float", "int"}, []string{"up", "down"})
+	defer eph.Ticker.Stop()
+	assertError(t, eph.AddStateMachine(StateConfig{
+		Name:   "service_status",
+		States: []string{"healthy", "down"},
+		Matrix: [][]float64{{0, 1}, {1, 0}},
+	}), nil)
+	assertError(t, eph.AddDerived(DerivedConfig{Name: "error_ratio", Expr: "int/up / 2"}), nil)
+	mux := eph.SetupMux()
+
+	const oneHot = `service_status_state{service_status="down"}`
+
+	t.Run("Lists state and derived series", func(t *testing.T) {
+		w := serve(t, mux, "GET", "/api/v1/series", "")
+		assertStatus(t, w.Code, http.StatusOK)
+
+		var infos []SeriesInfo
+		assertError(t, json.NewDecoder(w.Body).Decode(&infos), nil)
+		assertInt(t, len(infos), 8)
+		if infos[6].ID != "service_status" || infos[6].Machine == nil || infos[6].State.Value != "0" {
+			t.Errorf("Expected the state metric in the healthy state, got %+v", infos[6])
+		}
+		if infos[7].ID != "error_ratio" || infos[7].Derived == nil || infos[7].Derived.Expr != "int/up / 2" {
+			t.Errorf("Expected the derived metric with its expression, got %+v", infos[7])
+		}
+	})
+
+	t.Run("Gets a state metric by its state series", func(t *testing.T) {
+		w := serve(t, mux, "GET", seriesPath(oneHot), "")
+		assertStatus(t, w.Code, http.StatusOK)
+
+		var info SeriesInfo
+		assertError(t, json.NewDecoder(w.Body).Decode(&info), nil)
+		if info.ID != "service_status" {
+			t.Errorf("Expected service_status, got %s", info.ID)
+		}
+	})
+
+	t.Run("Cannot change or inject", func(t *testing.T) {
+		for _, id := range []string{"service_status", "error_ratio"} {
+			assertStatus(t, serve(t, mux, "PATCH", seriesPath(id), `{"size": 3}`).Code, http.StatusBadRequest)
+			assertStatus(t, serve(t, mux, "POST", "/control/inject", `{"id": "`+id+`", "value": "1"}`).Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("Deletes series", func(t *testing.T) {
+		for _, id := range []string{"error_ratio", oneHot} {
+			assertStatus(t, serve(t, mux, "DELETE", seriesPath(id), "").Code, http.StatusNoContent)
+			assertStatus(t, serve(t, mux, "DELETE", seriesPath(id), "").Code, http.StatusNotFound)
+		}
+		for _, id := range []string{"error_ratio", "service_status", oneHot} {
+			if _, ok := eph.Snapshot().Lookup(id); ok {
+				t.Errorf("Expected %s not to be served", id)
+			}
+		}
+		eph.Advance()
+	})
+}
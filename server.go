@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+const defShutdownTimeout = 10 * time.Second // Time in-flight requests get to finish
+
+// Run serves the endpoints on addr and advances buffers every tick until ctx is done.
+// Shutdown stops the tick loop first, then waits up to timeout for in-flight requests.
+func (eph *EPHandle) Run(ctx context.Context, addr string, timeout time.Duration) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	if eph.TLS != nil {
+		listener = tls.NewListener(listener, eph.TLS)
+	}
+
+	eph.Server = &http.Server{
+		Addr:      addr,
+		Handler:   eph.SetupMux(),
+		TLSConfig: eph.TLS,
+	}
+
+	// Run webserver in parallel to metric creation
+	served := make(chan error, 1)
+	go func() {
+		served <- eph.Server.Serve(listener)
+	}()
+	slog.Info("Listening", slog.String("addr", listener.Addr().String()), slog.Bool("tls", eph.TLS != nil))
+	eph.SetReady(true)
+
+	// Main loop that creates metrics for endpoint handlers
+	for {
+		select {
+		case <-eph.Ticker.C:
+			if !eph.Paused.Load() {
+				eph.Advance() // Moves every buffer forward and publishes a snapshot
+			}
+		case err := <-served:
+			eph.SetReady(false)
+			eph.Ticker.Stop()
+			return err
+		case <-ctx.Done():
+			eph.SetReady(false) // Stop new traffic before in-flight requests finish
+			eph.Ticker.Stop()
+			slog.Info("Shutting down", slog.Duration("timeout", timeout))
+
+			shutdown, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			if err := eph.Server.Shutdown(shutdown); err != nil {
+				return err
+			}
+			if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+				return err
+			}
+			slog.Info("Shutdown complete")
+			return nil
+		}
+	}
+}
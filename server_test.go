@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// freeAddr returns a local address that was free a moment ago
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assertError(t, err, nil)
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+func TestEPHandle_Run(t *testing.T) {
+	t.Run("Serves until cancelled then shuts down", func(t *testing.T) {
+		eph := NewEPHandle([]string{"exp", "float", "int"}, []string{"up", "down"})
+		addr := freeAddr(t)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			done <- eph.Run(ctx, addr, time.Second)
+		}()
+
+		// Wait for the listener
+		var resp *http.Response
+		var err error
+		for i := 0; i < 50; i++ {
+			resp, err = http.Get("http://" + addr + "/metrics")
+			if err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		assertError(t, err, nil)
+		resp.Body.Close()
+		assertStatus(t, resp.StatusCode, http.StatusOK)
+		if !eph.Ready.Load() {
+			t.Error("Expected the handle to be ready while serving")
+		}
+
+		cancel()
+		select {
+		case err := <-done:
+			assertError(t, err, nil)
+		case <-time.After(2 * time.Second):
+			t.Fatal("Expected Run to return after cancel")
+		}
+		if eph.Ready.Load() {
+			t.Error("Expected the handle not to be ready after shutdown")
+		}
+
+		_, err = http.Get("http://" + addr + "/metrics")
+		assertGotError(t, err)
+	})
+
+	t.Run("Returns listen errors", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		assertError(t, err, nil)
+		defer ln.Close()
+
+		eph := NewEPHandle([]string{"exp", "float", "int"}, []string{"up", "down"})
+		defer eph.Ticker.Stop()
+		err = eph.Run(context.Background(), ln.Addr().String(), time.Second)
+		assertGotError(t, err)
+	})
+}
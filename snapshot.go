@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Snapshot is an immutable view of every value served at one tick.
+// It is rebuilt by the tick loop (and control endpoints) then published atomically,
+// so scrape handlers never take a lock or contend with ShiftBuffers.
+type Snapshot struct {
+	Tick    uint64            // Number of ticks since start
+	Time    time.Time         // When this snapshot was published
+	Random  map[string]string // Random value by numeric type
+	Samples []Sample          // Series ordered by ID, built-in series first
+	index   map[string]int    // Sample position by ID
+}
+
+// Sample is the value of one series at the time of the snapshot
+type Sample struct {
+	ID      string            // Unique name with labels
+	Name    string            // Metric name
+	Labels  map[string]string // Metric labels, may be nil
+	NType   string            // Numeric Type
+	MAlgo   string            // Metric Algorithm Name
+	Value   string            // Value served
+	Values  []string          // Buffer the value came from, never modified after publishing
+	History *History          // Recently served values, nil when disabled
+	Omit    bool              // Left out of responses this tick
+	Empty   bool              // Responses including this sample are empty this tick
+}
+
+// Lookup returns the sample with the given ID
+func (s *Snapshot) Lookup(id string) (Sample, bool) {
+	i, ok := s.index[id]
+	if !ok {
+		return Sample{}, false
+	}
+	return s.Samples[i], true
+}
+
+// Match returns samples with the metric name that have every label in matchers
+func (s *Snapshot) Match(name string, matchers map[string]string) []Sample {
+	var samples []Sample
+	for _, sample := range s.Samples {
+		if sample.Name == name && matchLabels(sample.Labels, matchers) {
+			samples = append(samples, sample)
+		}
+	}
+	return samples
+}
+
+// served drops omitted samples, and reports whether the response should be empty
+func served(samples []Sample) ([]Sample, bool) {
+	kept := make([]Sample, 0, len(samples))
+	empty := false
+	for _, sample := range samples {
+		if sample.Omit {
+			continue
+		}
+		empty = empty || sample.Empty
+		kept = append(kept, sample)
+	}
+	return kept, empty
+}
+
+// Built-in series are named after their type and algorithm
+func builtinName(ntype, malgo string) string {
+	return fmt.Sprintf("Metric_%s_%s", ntype, malgo)
+}
+
+// Advance moves every buffer forward one tick and publishes the result
+func (eph *EPHandle) Advance() {
+	eph.advance(false)
+}
+
+// Step moves every buffer forward like a tick, outside the tick loop.
+// The time since the last tick is not counted as missed ticks.
+func (eph *EPHandle) Step() {
+	eph.advance(true)
+}
+
+func (eph *EPHandle) advance(step bool) {
+	start := time.Now()
+	eph.RandBuffers()  // Creates a new buffer every time for random data
+	eph.ShiftBuffers() // Creates or updates the cyclical algorithm buffer
+	eph.StepStates()   // Moves state machines to their next state
+	eph.ChurnSeries()  // Replaces a share of high-cardinality series
+	eph.publish(true)
+
+	now := time.Now()
+	var gap time.Duration
+	if !step {
+		gap = time.Duration(start.UnixNano() - eph.LastTick.Swap(now.UnixNano()))
+	}
+	eph.Internal.observeTick(now.Sub(start), gap)
+
+	// Targets mounted under a prefix advance with this handle
+	for _, target := range eph.Mounts {
+		if !target.Paused.Load() {
+			target.advance(step)
+		}
+	}
+}
+
+// Publish rebuilds the current snapshot without advancing the tick,
+// used after buffers are changed outside the tick loop.
+func (eph *EPHandle) Publish() {
+	eph.publish(false)
+}
+
+func (eph *EPHandle) publish(advance bool) {
+	eph.PubMU.Lock()
+	defer eph.PubMU.Unlock()
+
+	snap := &Snapshot{
+		Time:   time.Now(),
+		Random: make(map[string]string),
+		index:  make(map[string]int),
+	}
+	prev := eph.Snap.Load()
+	if prev != nil {
+		snap.Tick = prev.Tick
+	}
+	if advance {
+		snap.Tick++
+	}
+
+	// History records values once per tick, starting with the first snapshot
+	record := advance || prev == nil
+
+	eph.MetricsMU.RLock()
+	var builtin []Sample
+	for _, mt := range eph.MTypes {
+		mt.MU.Lock()
+		if len(mt.RandomBuffer) > 0 {
+			snap.Random[mt.Name] = mt.RandomBuffer[0]
+		}
+		mt.MU.Unlock()
+
+		for algo, buff := range mt.ShiftRegisters {
+			name := builtinName(buff.NType, buff.MAlgo)
+			sample := buff.sample(name, name, nil)
+			sample.Omit = mt.Overrides[algo].Faults.Omitted(snap.Tick)
+			sample.Empty = mt.Overrides[algo].Faults.Empty(snap.Tick)
+			builtin = append(builtin, sample)
+		}
+	}
+	sort.Slice(builtin, func(i, j int) bool {
+		return builtin[i].ID < builtin[j].ID
+	})
+	snap.Samples = builtin
+
+	for _, m := range eph.sortedMetrics() {
+		var samples []Sample
+		if m.Histogram != nil {
+			samples = m.Histogram.Samples(m.Config.Name, m.Config.Labels)
+		} else {
+			samples = []Sample{m.Buffer.sample(m.ID, m.Config.Name, m.Config.Labels)}
+		}
+		for i := range samples {
+			samples[i].Omit = m.Config.Faults.Omitted(snap.Tick)
+			samples[i].Empty = m.Config.Faults.Empty(snap.Tick)
+		}
+		snap.Samples = append(snap.Samples, samples...)
+	}
+	for _, sm := range eph.States {
+		snap.Samples = append(snap.Samples, sm.Samples()...)
+	}
+	eph.MetricsMU.RUnlock()
+
+	for i, sample := range snap.Samples {
+		snap.index[sample.ID] = i
+		if record && sample.History != nil && !sample.Omit {
+			sample.History.Record(Point{Time: snap.Time, Tick: snap.Tick, Value: sample.Value})
+		}
+	}
+
+	// Derived metrics see every series above, and the derived metrics before them
+	eph.MetricsMU.RLock()
+	for _, d := range eph.Derived {
+		sample := d.Sample(snap)
+		snap.index[sample.ID] = len(snap.Samples)
+		snap.Samples = append(snap.Samples, sample)
+		if record && sample.History != nil {
+			sample.History.Record(Point{Time: snap.Time, Tick: snap.Tick, Value: sample.Value})
+		}
+	}
+	eph.MetricsMU.RUnlock()
+
+	eph.Snap.Store(snap)
+}
+
+// Current snapshot, never nil after NewEPHandle
+func (eph *EPHandle) Snapshot() *Snapshot {
+	return eph.Snap.Load()
+}
+
+// sample reads the current value of the buffer under its lock
+func (cb *CycBuffer) sample(id, name string, labels map[string]string) Sample {
+	cb.MU.Lock()
+	defer cb.MU.Unlock()
+
+	return Sample{
+		ID:      id,
+		Name:    name,
+		Labels:  labels,
+		NType:   cb.NType,
+		MAlgo:   cb.MAlgo,
+		Value:   cb.served(),
+		Values:  cb.Values,
+		History: cb.History,
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestEPHandle_Publish(t *testing.T) {
+	eph := NewEPHandle([]string{"exp", "float", "int"}, []string{"up", "down"})
+	defer eph.Ticker.Stop()
+
+	snap := eph.Snapshot()
+	if snap.Tick != 0 {
+		t.Errorf("Expected first snapshot at tick 0, got %d", snap.Tick)
+	}
+	assertInt(t, len(snap.Samples), 6)
+	assertInt(t, len(snap.Random), 3)
+
+	t.Run("Samples are ordered by ID", func(t *testing.T) {
+		for i := 1; i < len(snap.Samples); i++ {
+			if snap.Samples[i-1].ID > snap.Samples[i].ID {
+				t.Errorf("Expected %s before %s", snap.Samples[i].ID, snap.Samples[i-1].ID)
+			}
+		}
+	})
+
+	t.Run("Advance publishes the shifted value", func(t *testing.T) {
+		eph.Advance()
+		next := eph.Snapshot()
+		if next.Tick != 1 {
+			t.Errorf("Expected tick 1, got %d", next.Tick)
+		}
+
+		sr := eph.MTypes["int"].ShiftRegisters["up"]
+		sample, ok := next.Lookup("Metric_int_up")
+		if !ok {
+			t.Fatal("Expected to find Metric_int_up")
+		}
+		if sample.Value != sr.Values[sr.Index] {
+			t.Errorf("Expected %s, got %s", sr.Values[sr.Index], sample.Value)
+		}
+	})
+
+	t.Run("Previous snapshot does not change", func(t *testing.T) {
+		before, _ := snap.Lookup("Metric_int_up")
+		eph.Advance()
+		after, _ := snap.Lookup("Metric_int_up")
+		if before.Value != after.Value {
+			t.Errorf("Expected held snapshot to keep %s, got %s", before.Value, after.Value)
+		}
+	})
+
+	t.Run("Publish keeps the tick", func(t *testing.T) {
+		tick := eph.Snapshot().Tick
+		eph.Publish()
+		if eph.Snapshot().Tick != tick {
+			t.Errorf("Expected tick %d, got %d", tick, eph.Snapshot().Tick)
+		}
+	})
+}
+
+// Run with -race to check scrapes, resets and ticks do not conflict
+func TestEPHandle_ConcurrentScrapes(t *testing.T) {
+	t.Setenv("INT_SIZE", strconv.Itoa(defSize)) // Restored after the resets below
+	eph := NewEPHandle([]string{"exp", "float", "int"}, []string{"up", "down"})
+	defer eph.Ticker.Stop()
+	mux := eph.SetupMux()
+
+	err := eph.AddTemplate(Template{
+		SeriesConfig: SeriesConfig{Name: "http_requests", Type: "int", Algo: "up"},
+		Cardinality:  map[string]int{"pod": 10},
+		Churn:        50,
+	})
+	assertError(t, err, nil)
+
+	targets := []string{"/metrics", "/rand/all", "/api/v1/series", "/series/int/up", "/series/http_requests", "/reset/INT_SIZE/3", "/reset/INT_SIZE/12"}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			eph.Advance()
+		}
+	}()
+	for _, target := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			method := "GET"
+			if strings.HasPrefix(target, "/reset") {
+				method = "POST"
+			}
+			for i := 0; i < 50; i++ {
+				r := httptest.NewRequest(method, target, nil)
+				w := httptest.NewRecorder()
+				mux.ServeHTTP(w, r)
+				assertStatus(t, w.Code, http.StatusOK)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,79 @@
+package main
+
+import "fmt"
+
+const defHold = 5 // Ticks a step holds its level
+
+// Step holds a level for Hold ticks before jumping to the next one.
+// Levels cycle through the list, or are random multiples of mod up to Limit * Mod.
+// With Return set, every level is followed by Return ticks at the Baseline.
+type Step struct {
+	Hold     int
+	Levels   []float64
+	Baseline float64
+	Return   int
+	Rand     *Rand // Source of random levels
+}
+
+// NewStepCycBuffer creates a series of plateaus with sharp changes between them
+func NewStepCycBuffer(maxSize, limit, tail int, mod float64, f string, st Step) *CycBuffer {
+	values := make([]string, 0, maxSize)
+
+	level := st.Baseline
+	for n := 0; len(values) < maxSize; n++ {
+		if len(st.Levels) > 0 {
+			level = st.Levels[n%len(st.Levels)]
+		} else {
+			level = randomLevel(limit, mod, level, st.Rand)
+		}
+		for i := 0; i < st.Hold && len(values) < maxSize; i++ {
+			values = append(values, FormatValue(level, f, tail))
+		}
+		for i := 0; i < st.Return && len(values) < maxSize; i++ {
+			values = append(values, FormatValue(st.Baseline, f, tail))
+		}
+	}
+
+	return &CycBuffer{
+		NType:   f,
+		MAlgo:   "step",
+		Values:  values,
+		MaxSize: maxSize,
+		Index:   0,
+		Rand:    st.Rand,
+	}
+}
+
+// randomLevel picks a multiple of mod from 0 to limit * mod that differs from prev,
+// so every step is a change
+func randomLevel(limit int, mod, prev float64, rng *Rand) float64 {
+	for {
+		level := float64(rng.IntN(limit+1)) * mod
+		if level != prev || limit == 0 {
+			return level
+		}
+	}
+}
+
+// step reads the hold and return ticks from the series parameters
+func (sc *SeriesConfig) step() Step {
+	return Step{
+		Hold:     int(sc.param("hold", defHold)),
+		Levels:   sc.Levels,
+		Baseline: sc.param("baseline", 0),
+		Return:   int(sc.param("return", 0)),
+		Rand:     sc.rand,
+	}
+}
+
+// validateStep checks a step holds for at least one tick
+func (sc *SeriesConfig) validateStep() error {
+	st := sc.step()
+	if st.Hold < 1 {
+		return fmt.Errorf("hold for metric %s must be at least 1", sc.Name)
+	}
+	if st.Return < 0 {
+		return fmt.Errorf("return for metric %s cannot be negative", sc.Name)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestNewStepCycBuffer(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   int
+		limit  int
+		mod    float64
+		step   Step
+		expect []string
+	}{
+		{
+			name:   "Cycles through levels",
+			size:   8,
+			step:   Step{Hold: 2, Levels: []float64{10, 50, 20}},
+			expect: []string{"10", "10", "50", "50", "20", "20", "10", "10"},
+		},
+		{
+			name:   "Returns to baseline",
+			size:   7,
+			step:   Step{Hold: 2, Levels: []float64{100}, Baseline: 5, Return: 1},
+			expect: []string{"100", "100", "5", "100", "100", "5", "100"},
+		},
+		{
+			name:   "Hold longer than the buffer",
+			size:   3,
+			step:   Step{Hold: 10, Levels: []float64{7, 8}},
+			expect: []string{"7", "7", "7"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cb := NewStepCycBuffer(tt.size, tt.limit, 0, tt.mod, "int", tt.step)
+			if !slices.Equal(cb.Values, tt.expect) {
+				t.Errorf("Expected %v, got %v", tt.expect, cb.Values)
+			}
+		})
+	}
+
+	t.Run("Random levels change every step", func(t *testing.T) {
+		cb := NewStepCycBuffer(100, 10, 0, 5, "int", Step{Hold: defHold})
+		assertInt(t, len(cb.Values), 100)
+		for i := 0; i < len(cb.Values); i += defHold {
+			level := parseSampleValue(cb.Values[i])
+			if level < 0 || level > 50 || int(level)%5 != 0 {
+				t.Errorf("Expected a multiple of 5 up to 50, got %v", level)
+			}
+			if i > 0 && cb.Values[i] == cb.Values[i-1] {
+				t.Errorf("Expected a change at %d, got %s twice", i, cb.Values[i])
+			}
+			for j := i + 1; j < i+defHold; j++ {
+				if cb.Values[j] != cb.Values[i] {
+					t.Errorf("Expected %s held at %d, got %s", cb.Values[i], j, cb.Values[j])
+				}
+			}
+		}
+	})
+}
+
+func TestSeriesConfig_Step(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  SeriesConfig
+		wantErr bool
+	}{
+		{name: "Levels", config: SeriesConfig{Name: "deploy_version", Type: "int", Algo: "step", Levels: []float64{1, 2, 3}, Params: map[string]float64{"hold": 30}}},
+		{name: "Random levels", config: SeriesConfig{Name: "queue_depth", Type: "float", Algo: "step"}},
+		{name: "Zero hold", config: SeriesConfig{Name: "queue_depth", Type: "int", Algo: "step", Params: map[string]float64{"hold": 0}}, wantErr: true},
+		{name: "Negative return", config: SeriesConfig{Name: "queue_depth", Type: "int", Algo: "step", Params: map[string]float64{"return": -1}}, wantErr: true},
+		{name: "Levels without step", config: SeriesConfig{Name: "queue_depth", Type: "int", Algo: "up", Levels: []float64{1}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewMetric(tt.config)
+			if tt.wantErr {
+				assertGotError(t, err)
+				return
+			}
+			assertError(t, err, nil)
+		})
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TargetConfig is one simulated scrape target with its own series.
+// It listens on its own Addr, or is served under a Prefix of the main address.
+type TargetConfig struct {
+	Name   string            `json:"name"`
+	Addr   string            `json:"addr,omitempty"`   // Own listen address, e.g. :9001
+	Prefix string            `json:"prefix,omitempty"` // Path on the main address, e.g. /web-1
+	Config string            `json:"config,omitempty"` // JSON file of user-defined metrics
+	Seed   uint64            `json:"seed,omitempty"`   // Makes values reproducible, random when 0
+	Env    map[string]string `json:"env,omitempty"`    // Env Var overrides, e.g. INT_SIZE
+}
+
+// Validate checks the target is served in exactly one place
+func (t *TargetConfig) Validate() error {
+	if t.Name == "" {
+		return fmt.Errorf("target has no name")
+	}
+	if (t.Addr == "") == (t.Prefix == "") {
+		return fmt.Errorf("target %s needs an addr or a prefix", t.Name)
+	}
+	if t.Prefix != "" && (!strings.HasPrefix(t.Prefix, "/") || strings.HasSuffix(t.Prefix, "/")) {
+		return fmt.Errorf("prefix of target %s must start with / and not end with /", t.Name)
+	}
+	return nil
+}
+
+// Build creates the target's handle and loads its metrics
+func (t *TargetConfig) Build() (*EPHandle, error) {
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+
+	eph := NewTargetEPHandle(NTypes, MAlgos, NewEnv(t.Env), NewRand(t.Seed))
+	if t.Config != "" {
+		if err := eph.LoadConfig(t.Config); err != nil {
+			eph.Ticker.Stop()
+			return nil, fmt.Errorf("target %s: %w", t.Name, err)
+		}
+	}
+	return eph, nil
+}
+
+// LoadTargets reads target definitions from a JSON file
+func LoadTargets(path string) ([]TargetConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read targets: %w", err)
+	}
+
+	var file struct {
+		Targets []TargetConfig `json:"targets"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("could not parse targets: %w", err)
+	}
+
+	names := make(map[string]bool)
+	for _, t := range file.Targets {
+		if err := t.Validate(); err != nil {
+			return nil, err
+		}
+		if names[t.Name] {
+			return nil, fmt.Errorf("duplicate target: %s", t.Name)
+		}
+		names[t.Name] = true
+	}
+	return file.Targets, nil
+}
+
+// Mount serves a target's endpoints under prefix of this handle's address.
+// The target advances with this handle instead of its own ticker.
+func (eph *EPHandle) Mount(prefix string, target *EPHandle) {
+	target.Ticker.Stop()
+	if eph.Mounts == nil {
+		eph.Mounts = make(map[string]*EPHandle)
+	}
+	eph.Mounts[prefix] = target
+}
+
+// RunTargets serves the handle on addr, with targets that have a prefix mounted on it,
+// and targets that have an address on their own, until ctx is done or any of them fails
+func RunTargets(ctx context.Context, eph *EPHandle, addr string, timeout time.Duration, targets []TargetConfig) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type listener struct {
+		name string
+		eph  *EPHandle
+		addr string
+	}
+	listeners := []listener{{name: "main", eph: eph, addr: addr}}
+
+	for _, t := range targets {
+		target, err := t.Build()
+		if err != nil {
+			return err
+		}
+		target.Auth = eph.Auth
+		target.TLS = eph.TLS
+		if t.Prefix != "" {
+			eph.Mount(t.Prefix, target)
+			continue
+		}
+		listeners = append(listeners, listener{name: t.Name, eph: target, addr: t.Addr})
+	}
+
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func() {
+			err := l.eph.Run(ctx, l.addr, timeout)
+			if err != nil {
+				err = fmt.Errorf("target %s: %w", l.name, err)
+			}
+			errs <- err
+		}()
+	}
+
+	// One failure stops every target
+	var first error
+	for range listeners {
+		if err := <-errs; err != nil && first == nil {
+			first = err
+			cancel()
+		}
+	}
+	return first
+}
+
+// Rand is a random source shared by the generators of one handle.
+// Seeding it makes a target's values reproducible.
+// A nil *Rand uses the global source, which is how unseeded handles run.
+type Rand struct {
+	MU  sync.Mutex
+	src *rand.Rand
+}
+
+// NewRand returns a source for the seed, or nil for the global source when the seed is 0
+func NewRand(seed uint64) *Rand {
+	if seed == 0 {
+		return nil
+	}
+	return &Rand{src: rand.New(rand.NewPCG(seed, seed))}
+}
+
+func (r *Rand) Float64() float64 {
+	if r == nil {
+		return rand.Float64()
+	}
+	r.MU.Lock()
+	defer r.MU.Unlock()
+	return r.src.Float64()
+}
+
+func (r *Rand) NormFloat64() float64 {
+	if r == nil {
+		return rand.NormFloat64()
+	}
+	r.MU.Lock()
+	defer r.MU.Unlock()
+	return r.src.NormFloat64()
+}
+
+func (r *Rand) ExpFloat64() float64 {
+	if r == nil {
+		return rand.ExpFloat64()
+	}
+	r.MU.Lock()
+	defer r.MU.Unlock()
+	return r.src.ExpFloat64()
+}
+
+func (r *Rand) IntN(n int) int {
+	if r == nil {
+		return rand.IntN(n)
+	}
+	r.MU.Lock()
+	defer r.MU.Unlock()
+	return r.src.IntN(n)
+}
+
+func (r *Rand) Int32N(n int32) int32 {
+	if r == nil {
+		return rand.Int32N(n)
+	}
+	r.MU.Lock()
+	defer r.MU.Unlock()
+	return r.src.Int32N(n)
+}
+
+func (r *Rand) Perm(n int) []int {
+	if r == nil {
+		return rand.Perm(n)
+	}
+	r.MU.Lock()
+	defer r.MU.Unlock()
+	return r.src.Perm(n)
+}
+
+// Env holds Env Var overrides for one handle, read before the process environment,
+// so targets in one process can each have their own INT_SIZE and so on.
+// Resets only change these overrides, the process environment is never written.
+// A nil *Env reads the process environment and ignores changes.
+type Env struct {
+	MU   sync.RWMutex
+	Vars map[string]string
+}
+
+// NewEnv returns overrides for the vars, which may be empty
+func NewEnv(vars map[string]string) *Env {
+	env := &Env{Vars: make(map[string]string, len(vars))}
+	for k, v := range vars {
+		env.Vars[k] = v
+	}
+	return env
+}
+
+// ProcessEnv copies the process environment into overrides for the main handle
+func ProcessEnv() *Env {
+	vars := make(map[string]string)
+	for _, kv := range os.Environ() {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			vars[k] = v
+		}
+	}
+	return NewEnv(vars)
+}
+
+// Get works like FillEnvVar
+func (e *Env) Get(ev string) string {
+	if e != nil {
+		e.MU.RLock()
+		value, ok := e.Vars[ev]
+		e.MU.RUnlock()
+		if ok && value != "" {
+			return value
+		}
+	}
+	return FillEnvVar(ev)
+}
+
+// Int works like FillEnvVarInt
+func (e *Env) Int(ev string, def int) int {
+	value := e.Get(ev)
+	if value == "ENOENT" {
+		return def
+	}
+	return parseEnvInt(ev, value, def)
+}
+
+// Set changes a var for this handle only
+func (e *Env) Set(ev, value string) {
+	if e == nil {
+		return
+	}
+	e.MU.Lock()
+	defer e.MU.Unlock()
+	e.Vars[ev] = value
+}
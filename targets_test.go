@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRand(t *testing.T) {
+	t.Run("Zero seed uses the global source", func(t *testing.T) {
+		if NewRand(0) != nil {
+			t.Error("Expected a nil source for seed 0")
+		}
+		var r *Rand
+		if v := r.IntN(10); v < 0 || v >= 10 {
+			t.Errorf("Expected 0 to 9, got %d", v)
+		}
+	})
+
+	t.Run("Same seed, same values", func(t *testing.T) {
+		a, b := NewRand(42), NewRand(42)
+		for i := 0; i < 100; i++ {
+			if a.Float64() != b.Float64() {
+				t.Fatal("Expected seeded sources to match")
+			}
+		}
+	})
+}
+
+func TestEnv(t *testing.T) {
+	t.Setenv("FLOAT_SIZE", "20")
+	t.Setenv("FLOAT_LIMIT", "30")
+	t.Setenv("FLOAT_TAIL", "")
+
+	env := NewEnv(map[string]string{"FLOAT_SIZE": "5"})
+	assertInt(t, env.Int("FLOAT_SIZE", defSize), 5)
+	assertInt(t, env.Int("FLOAT_LIMIT", defLimit), 30)
+	assertInt(t, env.Int("FLOAT_TAIL", defTail), defTail)
+
+	env.Set("FLOAT_LIMIT", "7")
+	assertInt(t, env.Int("FLOAT_LIMIT", defLimit), 7)
+	if os.Getenv("FLOAT_LIMIT") != "30" {
+		t.Errorf("Expected the process environment to be unchanged, got %s", os.Getenv("FLOAT_LIMIT"))
+	}
+
+	var process *Env
+	assertInt(t, process.Int("FLOAT_SIZE", defSize), 20)
+}
+
+func TestNewTargetEPHandle(t *testing.T) {
+	build := func(seed uint64) *EPHandle {
+		eph := NewTargetEPHandle([]string{"exp", "float", "int"}, []string{"up", "down"}, NewEnv(map[string]string{"INT_SIZE": "4"}), NewRand(seed))
+		assertError(t, eph.AddMetric(SeriesConfig{Name: "latency", Type: "float", Algo: "lognormal", Tail: 3}), nil)
+		return eph
+	}
+
+	a, b := build(7), build(7)
+	defer a.Ticker.Stop()
+	defer b.Ticker.Stop()
+	assertInt(t, len(a.MTypes["int"].ShiftRegisters["up"].Values), 4)
+
+	for i := 0; i < 5; i++ {
+		a.Advance()
+		b.Advance()
+	}
+	sa, sb := a.Snapshot(), b.Snapshot()
+	for i := range sa.Samples {
+		if sa.Samples[i].Value != sb.Samples[i].Value {
+			t.Errorf("Expected %s to match with the same seed, got %s and %s", sa.Samples[i].ID, sa.Samples[i].Value, sb.Samples[i].Value)
+		}
+	}
+	if sa.Random["float"] != sb.Random["float"] {
+		t.Errorf("Expected random values to match with the same seed")
+	}
+
+	t.Run("Reset only changes the target", func(t *testing.T) {
+		t.Setenv("INT_SIZE", "")
+		r := httptest.NewRequest("POST", "/reset/INT_SIZE/6", nil)
+		w := httptest.NewRecorder()
+		a.SetupMux().ServeHTTP(w, r)
+		assertStatus(t, w.Code, http.StatusOK)
+
+		assertInt(t, len(a.MTypes["int"].ShiftRegisters["up"].Values), 6)
+		assertInt(t, len(b.MTypes["int"].ShiftRegisters["up"].Values), 4)
+		if os.Getenv("INT_SIZE") != "" {
+			t.Errorf("Expected the process environment to be unchanged, got %s", os.Getenv("INT_SIZE"))
+		}
+	})
+
+	t.Run("History size comes from the target", func(t *testing.T) {
+		eph := NewTargetEPHandle([]string{"int"}, []string{"up"}, NewEnv(map[string]string{"HISTORY_SIZE": "3"}), nil)
+		defer eph.Ticker.Stop()
+		assertError(t, eph.AddMetric(SeriesConfig{Name: "latency", Type: "float", Algo: "lognormal", Buckets: []float64{1}}), nil)
+		assertError(t, eph.AddDerived(DerivedConfig{Name: "double", Expr: "int/up * 2"}), nil)
+		assertError(t, eph.AddStateMachine(StateConfig{Name: "status", States: []string{"up", "down"}, Matrix: [][]float64{{0, 1}, {1, 0}}}), nil)
+		for range 5 {
+			eph.Advance()
+		}
+
+		for _, sample := range eph.Snapshot().Samples {
+			if sample.History == nil {
+				t.Errorf("Expected %s to keep history", sample.ID)
+				continue
+			}
+			assertInt(t, len(sample.History.Last(10)), 3)
+		}
+	})
+}
+
+func TestEPHandle_Mount(t *testing.T) {
+	t.Setenv("INT_SIZE", "")
+	eph := NewEPHandle([]string{"exp", "float", "int"}, []string{"up", "down"})
+	defer eph.Ticker.Stop()
+
+	target := NewTargetEPHandle([]string{"exp", "float", "int"}, []string{"up", "down"}, NewEnv(nil), nil)
+	assertError(t, target.AddMetric(SeriesConfig{Name: "web_requests", Type: "int", Algo: "up"}), nil)
+	eph.Mount("/web-1", target)
+	mux := eph.SetupMux()
+
+	eph.Advance()
+	if target.Snapshot().Tick != 1 {
+		t.Errorf("Expected the mounted target to advance, got tick %d", target.Snapshot().Tick)
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		code   int
+		expect string
+	}{
+		{name: "Target metrics", target: "/web-1/metrics", code: http.StatusOK, expect: "web_requests: "},
+		{name: "Target series", target: "/web-1/series/int/up", code: http.StatusOK, expect: "Metric_int_up: "},
+		{name: "Main metrics", target: "/metrics", code: http.StatusOK, expect: "Metric_int_up: "},
+		{name: "Main has no target metric", target: "/series/web_requests", code: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.target, nil)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
+			assertStatus(t, w.Code, tt.code)
+			assertStringContains(t, w.Body.String(), tt.expect)
+		})
+	}
+
+	t.Run("Resets on main do not reach the target", func(t *testing.T) {
+		assertStatus(t, serve(t, mux, "POST", "/reset/INT_SIZE/3", "").Code, http.StatusOK)
+		assertStatus(t, serve(t, mux, "POST", "/web-1/reset/INT_TAIL/2", "").Code, http.StatusOK)
+
+		assertInt(t, len(eph.MTypes["int"].ShiftRegisters["up"].Values), 3)
+		assertInt(t, len(target.MTypes["int"].ShiftRegisters["up"].Values), defSize)
+		if os.Getenv("INT_SIZE") != "" {
+			t.Errorf("Expected the process environment to be unchanged, got %s", os.Getenv("INT_SIZE"))
+		}
+	})
+}
+
+func TestLoadTargets(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    int
+		wantErr bool
+	}{
+		{name: "Addresses and prefixes", content: `{"targets": [{"name": "a", "addr": ":9001", "seed": 1}, {"name": "b", "prefix": "/b", "env": {"INT_SIZE": "3"}}]}`, want: 2},
+		{name: "Neither addr nor prefix", content: `{"targets": [{"name": "a"}]}`, wantErr: true},
+		{name: "Both addr and prefix", content: `{"targets": [{"name": "a", "addr": ":9001", "prefix": "/a"}]}`, wantErr: true},
+		{name: "Prefix without slash", content: `{"targets": [{"name": "a", "prefix": "a"}]}`, wantErr: true},
+		{name: "Duplicate names", content: `{"targets": [{"name": "a", "addr": ":9001"}, {"name": "a", "addr": ":9002"}]}`, wantErr: true},
+		{name: "Invalid JSON", content: `{"targets": [`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "targets.json")
+			assertError(t, os.WriteFile(path, []byte(tt.content), 0o644), nil)
+
+			targets, err := LoadTargets(path)
+			if tt.wantErr {
+				assertGotError(t, err)
+				return
+			}
+			assertError(t, err, nil)
+			assertInt(t, len(targets), tt.want)
+		})
+	}
+}
+
+func TestRunTargets(t *testing.T) {
+	eph := NewEPHandle([]string{"exp", "float", "int"}, []string{"up", "down"})
+	mainAddr, targetAddr := freeAddr(t), freeAddr(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- RunTargets(ctx, eph, mainAddr, time.Second, []TargetConfig{
+			{Name: "own", Addr: targetAddr, Seed: 1},
+			{Name: "mounted", Prefix: "/mounted", Seed: 2},
+		})
+	}()
+
+	for _, url := range []string{"http://" + mainAddr + "/metrics", "http://" + targetAddr + "/metrics", "http://" + mainAddr + "/mounted/metrics"} {
+		var err error
+		var resp *http.Response
+		for i := 0; i < 50; i++ {
+			if resp, err = http.Get(url); err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		assertError(t, err, nil)
+		resp.Body.Close()
+		assertStatus(t, resp.StatusCode, http.StatusOK)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		assertError(t, err, nil)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected RunTargets to return after cancel")
+	}
+}
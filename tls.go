@@ -0,0 +1,169 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	defTLSHosts  = "localhost,127.0.0.1,::1" // Names in a self-signed certificate
+	wrongTLSHost = "wrong-host.invalid"      // Only name in a wrong-host certificate
+)
+
+// TLSFaults are certificates a scraper should reject, served on purpose
+var TLSFaults = []string{"expired", "wrong-host"}
+
+// TLSOptions choose how endpoints are served over HTTPS.
+// With no cert, key, self-signed or fault set, endpoints are plaintext.
+type TLSOptions struct {
+	Cert       string // PEM certificate file
+	Key        string // PEM private key file
+	SelfSigned bool   // Generate a certificate at startup instead of reading one
+	Hosts      string // Comma separated DNS names and IPs of a generated certificate
+	ClientCA   string // PEM CA file, when set clients must present a certificate it signed
+	Fault      string // Generate a certificate that fails verification, one of TLSFaults
+}
+
+// Enabled is true when endpoints are served over HTTPS
+func (o TLSOptions) Enabled() bool {
+	return o.Cert != "" || o.Key != "" || o.SelfSigned || o.Fault != ""
+}
+
+// Validate checks the options make sense together
+func (o TLSOptions) Validate() error {
+	if (o.Cert == "") != (o.Key == "") {
+		return errors.New("TLS needs both a cert and a key")
+	}
+	if o.Cert != "" && (o.SelfSigned || o.Fault != "") {
+		return errors.New("TLS cert files cannot be used with a self-signed or fault certificate")
+	}
+	if o.Fault != "" && !slices.Contains(TLSFaults, o.Fault) {
+		return fmt.Errorf("unknown TLS fault %s, expected one of %v", o.Fault, TLSFaults)
+	}
+	if o.ClientCA != "" && !o.Enabled() {
+		return errors.New("TLS client CA needs a cert, key or self-signed certificate")
+	}
+	return nil
+}
+
+// Config loads or generates the server certificate, and the client CA for mTLS.
+// It returns nil when TLS is not enabled.
+func (o TLSOptions) Config() (*tls.Config, error) {
+	if !o.Enabled() {
+		return nil, nil
+	}
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+
+	var cert tls.Certificate
+	var err error
+	if o.Cert != "" {
+		cert, err = tls.LoadX509KeyPair(o.Cert, o.Key)
+	} else {
+		cert, err = o.generate(time.Now())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if o.ClientCA != "" {
+		pem, err := os.ReadFile(o.ClientCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in TLS client CA %s", o.ClientCA)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// generate makes the self-signed certificate, broken as the fault asks
+func (o TLSOptions) generate(now time.Time) (tls.Certificate, error) {
+	hosts := strings.Split(o.Hosts, ",")
+	if o.Hosts == "" {
+		hosts = strings.Split(defTLSHosts, ",")
+	}
+	notBefore, notAfter := now.Add(-time.Hour), now.Add(365*24*time.Hour)
+
+	switch o.Fault {
+	case "expired":
+		notBefore, notAfter = now.Add(-48*time.Hour), now.Add(-24*time.Hour)
+	case "wrong-host":
+		hosts = []string{wrongTLSHost}
+	}
+
+	cert, err := SelfSignedCert(hosts, notBefore, notAfter)
+	if err != nil {
+		return cert, err
+	}
+	slog.Info("Generated self-signed certificate",
+		slog.Any("hosts", hosts),
+		slog.Time("not_after", notAfter),
+		slog.String("fault", o.Fault))
+	return cert, nil
+}
+
+// SelfSignedCert makes a certificate for the hosts that signs itself,
+// so a client can trust it by adding its Leaf to the root pool.
+// It may also be used as a client certificate for mTLS.
+func SelfSignedCert(hosts []string, notBefore, notAfter time.Time) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"toadlester"}, CommonName: hosts[0]},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, h := range hosts {
+		h = strings.TrimSpace(h)
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else if h != "" {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, nil
+}
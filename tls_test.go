@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writePEM saves a certificate and its key for loading from files
+func writePEM(t *testing.T, cert tls.Certificate) (certFile, keyFile string) {
+	t.Helper()
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	assertError(t, err, nil)
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0o600)
+	assertError(t, err, nil)
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0o600)
+	assertError(t, err, nil)
+	return certFile, keyFile
+}
+
+func TestTLSOptions_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    TLSOptions
+		wantErr bool
+	}{
+		{name: "Plaintext", opts: TLSOptions{}},
+		{name: "Cert files", opts: TLSOptions{Cert: "cert.pem", Key: "key.pem"}},
+		{name: "Self-signed", opts: TLSOptions{SelfSigned: true, ClientCA: "ca.pem"}},
+		{name: "Fault", opts: TLSOptions{Fault: "expired"}},
+		{name: "Cert without a key", opts: TLSOptions{Cert: "cert.pem"}, wantErr: true},
+		{name: "Cert files and self-signed", opts: TLSOptions{Cert: "cert.pem", Key: "key.pem", SelfSigned: true}, wantErr: true},
+		{name: "Unknown fault", opts: TLSOptions{Fault: "revoked"}, wantErr: true},
+		{name: "Client CA without TLS", opts: TLSOptions{ClientCA: "ca.pem"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.opts.Validate()
+			if tt.wantErr {
+				assertGotError(t, err)
+				return
+			}
+			assertError(t, err, nil)
+		})
+	}
+}
+
+func TestTLSOptions_Config(t *testing.T) {
+	t.Run("Plaintext has no config", func(t *testing.T) {
+		config, err := TLSOptions{}.Config()
+		assertError(t, err, nil)
+		if config != nil {
+			t.Error("Expected no TLS config")
+		}
+	})
+
+	t.Run("Loads cert files", func(t *testing.T) {
+		cert, err := SelfSignedCert([]string{"localhost"}, time.Now(), time.Now().Add(time.Hour))
+		assertError(t, err, nil)
+		certFile, keyFile := writePEM(t, cert)
+
+		config, err := TLSOptions{Cert: certFile, Key: keyFile, ClientCA: certFile}.Config()
+		assertError(t, err, nil)
+		assertInt(t, len(config.Certificates), 1)
+		if config.ClientAuth != tls.RequireAndVerifyClientCert {
+			t.Errorf("Expected client certificates to be required, got %v", config.ClientAuth)
+		}
+	})
+
+	t.Run("Fails on a missing cert", func(t *testing.T) {
+		_, err := TLSOptions{Cert: "missing.pem", Key: "missing.pem"}.Config()
+		assertGotError(t, err)
+	})
+
+	t.Run("Fails on an empty client CA", func(t *testing.T) {
+		empty := filepath.Join(t.TempDir(), "ca.pem")
+		assertError(t, os.WriteFile(empty, nil, 0o600), nil)
+		_, err := TLSOptions{SelfSigned: true, ClientCA: empty}.Config()
+		assertGotError(t, err)
+	})
+}
+
+func TestEPHandle_RunTLS(t *testing.T) {
+	client, err := SelfSignedCert([]string{"client"}, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	assertError(t, err, nil)
+	clientCA, _ := writePEM(t, client)
+
+	tests := []struct {
+		name     string
+		opts     TLSOptions
+		clientCA bool // Present the client certificate
+		wantErr  bool
+	}{
+		{name: "Self-signed", opts: TLSOptions{SelfSigned: true}},
+		{name: "Expired fault", opts: TLSOptions{Fault: "expired"}, wantErr: true},
+		{name: "Wrong host fault", opts: TLSOptions{Fault: "wrong-host"}, wantErr: true},
+		{name: "Mutual TLS with a client certificate", opts: TLSOptions{SelfSigned: true, ClientCA: clientCA}, clientCA: true},
+		{name: "Mutual TLS without a client certificate", opts: TLSOptions{SelfSigned: true, ClientCA: clientCA}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := tt.opts.Config()
+			assertError(t, err, nil)
+
+			eph := NewEPHandle([]string{"exp", "float", "int"}, []string{"up", "down"})
+			eph.TLS = config
+			addr := freeAddr(t)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error, 1)
+			go func() {
+				done <- eph.Run(ctx, addr, time.Second)
+			}()
+			defer func() {
+				cancel()
+				assertError(t, <-done, nil)
+			}()
+
+			// Trust the generated certificate, so only the fault can fail
+			roots := x509.NewCertPool()
+			roots.AddCert(config.Certificates[0].Leaf)
+			clientConfig := &tls.Config{RootCAs: roots, ServerName: "localhost"}
+			if tt.clientCA {
+				clientConfig.Certificates = []tls.Certificate{client}
+			}
+			httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: clientConfig}}
+
+			for i := 0; i < 50 && !eph.Ready.Load(); i++ {
+				time.Sleep(10 * time.Millisecond)
+			}
+			resp, err := httpClient.Get("https://" + addr + "/metrics")
+			if tt.wantErr {
+				assertGotError(t, err)
+				return
+			}
+			assertError(t, err, nil)
+			resp.Body.Close()
+			assertStatus(t, resp.StatusCode, http.StatusOK)
+		})
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed ui
+var uiFiles embed.FS
+
+// UIHandler serves the dashboard, its pages call the API with paths relative to /ui/,
+// so it also works for targets mounted under a prefix
+func UIHandler() http.Handler {
+	root, _ := fs.Sub(uiFiles, "ui") // The directory is embedded, it always exists
+	return http.FileServerFS(root)
+}
+
+// uiRedirect sends /ui to /ui/ with a relative Location,
+// since http.Redirect would drop the prefix of a mounted target
+func uiRedirect(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Location", "ui/")
+	w.WriteHeader(http.StatusMovedPermanently)
+}
@@ -0,0 +1,206 @@
+"use strict";
+
+// Paths are relative to /ui/, so the dashboard also works under a target's prefix
+const base = "../";
+const points = 60; // Ticks shown in each sparkline
+
+const el = (id) => document.getElementById(id);
+const token = el("token");
+token.value = sessionStorage.getItem("toadlester-token") || "";
+token.addEventListener("change", () => sessionStorage.setItem("toadlester-token", token.value));
+
+let interval = 1; // Seconds between ticks, read from /control
+
+// parseDuration reads a Go duration like 1s, 250ms or 1m30s as seconds
+function parseDuration(s) {
+  const units = { h: 3600, m: 60, s: 1, ms: 1e-3, us: 1e-6, "µs": 1e-6, ns: 1e-9 };
+  let total = 0;
+  for (const [, n, unit] of s.matchAll(/([\d.]+)(h|ms|m|s|us|µs|ns)/g)) {
+    total += parseFloat(n) * units[unit];
+  }
+  return total || 1;
+}
+
+async function get(path) {
+  const resp = await fetch(base + path);
+  if (!resp.ok) {
+    throw new Error(`${resp.status} ${(await resp.text()).trim()}`);
+  }
+  return resp.json();
+}
+
+// send calls a control endpoint with the token, when one is set
+async function send(method, path, body) {
+  const headers = {};
+  if (token.value) {
+    headers.Authorization = "Bearer " + token.value;
+  }
+  if (body !== undefined) {
+    headers["Content-Type"] = "application/json";
+  }
+  const resp = await fetch(base + path, {
+    method,
+    headers,
+    body: body === undefined ? undefined : JSON.stringify(body),
+  });
+  const text = (await resp.text()).trim();
+  if (!resp.ok) {
+    throw new Error(`${resp.status} ${text}`);
+  }
+  return text;
+}
+
+function message(text, error) {
+  const m = el("message");
+  m.textContent = text;
+  m.className = error ? "error" : "";
+}
+
+// act runs a control action, reporting the outcome and refreshing right away
+async function act(what, fn) {
+  try {
+    await fn();
+    message(what + " done", false);
+  } catch (err) {
+    message(`${what} failed: ${err.message}`, true);
+  }
+  refresh();
+}
+
+function seriesID(metric) {
+  const labels = Object.keys(metric)
+    .filter((k) => k !== "__name__")
+    .sort()
+    .map((k) => `${k}=${JSON.stringify(metric[k])}`);
+  return labels.length ? `${metric.__name__}{${labels.join(",")}}` : metric.__name__;
+}
+
+function sparkline(values) {
+  const ns = "http://www.w3.org/2000/svg";
+  const svg = document.createElementNS(ns, "svg");
+  svg.setAttribute("viewBox", "0 0 100 40");
+  svg.setAttribute("preserveAspectRatio", "none");
+
+  const nums = values.map(Number);
+  const finite = nums.filter(Number.isFinite);
+  if (finite.length < 2) {
+    return svg;
+  }
+  const min = Math.min(...finite);
+  const span = Math.max(...finite) - min || 1;
+  const line = document.createElementNS(ns, "polyline");
+  line.setAttribute(
+    "points",
+    nums
+      .map((v, i) => (Number.isFinite(v) ? `${(i / (nums.length - 1)) * 100},${38 - ((v - min) / span) * 36}` : null))
+      .filter(Boolean)
+      .join(" "),
+  );
+  svg.appendChild(line);
+  return svg;
+}
+
+function card(id, values) {
+  const div = document.createElement("div");
+  div.className = "card";
+
+  const title = document.createElement("div");
+  title.className = "id";
+  title.textContent = id;
+  title.title = id;
+
+  const value = document.createElement("div");
+  value.className = "value";
+  value.textContent = values.length ? values[values.length - 1] : "–";
+
+  div.append(title, value, sparkline(values));
+  return div;
+}
+
+async function renderSeries(index) {
+  const now = Date.now() / 1000;
+  const names = [...new Set(index.series.map((s) => s.name))];
+  const results = await Promise.all(
+    names.map((name) => {
+      const q = new URLSearchParams({
+        query: name,
+        start: (now - points * interval).toFixed(3),
+        end: now.toFixed(3),
+        step: interval.toString(),
+      });
+      return get("api/v1/query_range?" + q).then((r) => r.data.result);
+    }),
+  );
+
+  const cards = results.flat().map((r) => card(seriesID(r.metric), r.values.map((v) => v[1])));
+  el("series").replaceChildren(...cards);
+}
+
+function renderConfig(infos) {
+  const rows = infos.map((info) => {
+    const c = info.config;
+    const other = { ...c };
+    for (const k of ["name", "labels", "type", "algo", "size", "limit", "tail", "mod"]) {
+      delete other[k];
+    }
+    const cells = [info.id, c.type, c.algo, c.size, c.limit, c.tail, c.mod, Object.keys(other).length ? JSON.stringify(other) : ""];
+    const tr = document.createElement("tr");
+    for (const cell of cells) {
+      const td = document.createElement("td");
+      td.textContent = cell ?? "";
+      tr.appendChild(td);
+    }
+    return tr;
+  });
+  el("config").tBodies[0].replaceChildren(...rows);
+
+  // Keep the chosen series when the list changes
+  const select = el("inject-series");
+  const chosen = select.value;
+  select.replaceChildren(
+    ...infos.map((info) => {
+      const opt = document.createElement("option");
+      opt.value = opt.textContent = info.id;
+      return opt;
+    }),
+  );
+  if (infos.some((info) => info.id === chosen)) {
+    select.value = chosen;
+  }
+}
+
+let timer;
+
+async function refresh() {
+  clearTimeout(timer);
+  try {
+    const status = await get("control");
+    interval = parseDuration(status.interval);
+    el("status").textContent = `tick ${status.tick} · every ${status.interval} · ${status.paused ? "paused" : "running"}`;
+
+    const [index, infos] = await Promise.all([get(""), get("api/v1/series")]);
+    await renderSeries(index);
+    renderConfig(infos);
+  } catch (err) {
+    el("status").textContent = "unreachable: " + err.message;
+  }
+  timer = setTimeout(refresh, Math.max(interval, 1) * 1000);
+}
+
+el("pause").addEventListener("click", () => act("Pause", () => send("POST", "control/pause")));
+el("resume").addEventListener("click", () => act("Resume", () => send("POST", "control/resume")));
+el("step").addEventListener("click", () => act("Step", () => send("POST", "control/step")));
+
+el("reset").addEventListener("submit", (e) => {
+  e.preventDefault();
+  const v = `${el("reset-type").value}_${el("reset-param").value}`;
+  act("Reset " + v, () => send("POST", `reset/${v}/${encodeURIComponent(el("reset-value").value)}`));
+});
+
+el("inject").addEventListener("submit", (e) => {
+  e.preventDefault();
+  const id = el("inject-series").value;
+  act("Inject " + id, () => send("POST", "control/inject", { id, value: el("inject-value").value }));
+});
+
+refresh();
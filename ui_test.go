@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestUIHandler(t *testing.T) {
+	eph := NewEPHandle([]string{"exp", "float", "int"}, []string{"up", "down"})
+	defer eph.Ticker.Stop()
+	target := NewTargetEPHandle([]string{"int"}, []string{"up"}, NewEnv(nil), nil)
+	eph.Mount("/web-1", target)
+	mux := eph.SetupMux()
+
+	tests := []struct {
+		name     string
+		target   string
+		wantCode int
+		expect   string
+	}{
+		{name: "Page", target: "/ui/", wantCode: http.StatusOK, expect: "<title>toadlester</title>"},
+		{name: "Script", target: "/ui/app.js", wantCode: http.StatusOK, expect: "control/inject"},
+		{name: "Styles", target: "/ui/style.css", wantCode: http.StatusOK, expect: ".card"},
+		{name: "Mounted target page", target: "/web-1/ui/", wantCode: http.StatusOK, expect: "<title>toadlester</title>"},
+		{name: "Missing file", target: "/ui/toad.png", wantCode: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(t, mux, "GET", tt.target, "")
+			assertStatus(t, w.Code, tt.wantCode)
+			assertStringContains(t, w.Body.String(), tt.expect)
+		})
+	}
+
+	t.Run("Redirects to the page", func(t *testing.T) {
+		w := serve(t, mux, "GET", "/ui", "")
+		assertStatus(t, w.Code, http.StatusMovedPermanently)
+		if w.Header().Get("Location") != "ui/" {
+			t.Errorf("Expected a relative redirect to ui/, got %s", w.Header().Get("Location"))
+		}
+	})
+}